@@ -1,9 +0,0 @@
-package main
-
-import "testing"
-
-func TestBasic(t *testing.T) {
-    if (emptyVector().X != 0) {
-        t.Error("Failed")
-    }
-}
@@ -0,0 +1,68 @@
+package tracer
+
+import (
+    "math"
+    "../vector"
+)
+
+// Largest difference between the displayed channels of two colors
+func contrast(a raytracer.Vector, b raytracer.Vector) float64 {
+    clip(&a)
+    clip(&b)
+    return math.Max(math.Abs(a.X - b.X), math.Max(math.Abs(a.Y - b.Y), math.Abs(a.Z - b.Z)))
+}
+
+// Whether the pixel differs from any of its four neighbors in the first pass
+func (renderer *Renderer) needsRefinement(initial *Framebuffer, x int, y int) bool {
+    color := initial.At(x, y)
+    neighbors := [4][2]int{{x - 1, y}, {x + 1, y}, {x, y - 1}, {x, y + 1}}
+    for _, neighbor := range neighbors {
+        if neighbor[0] < 0 || neighbor[0] >= initial.width || neighbor[1] < 0 || neighbor[1] >= initial.height {
+            continue
+        }
+        if contrast(color, initial.At(neighbor[0], neighbor[1])) > renderer.options.AdaptiveThreshold {
+            return true
+        }
+    }
+    return false
+}
+
+// Second adaptive pass: pixels that stand out from their neighbors in the
+// one sample per pixel image are subdivided. Reads only from initial, so
+// tiles stay independent.
+func (renderer *Renderer) refineTile(initial *Framebuffer, framebuffer *Framebuffer, tile Tile) {
+    stats := newRenderStats(renderer.options.MaxDepth)
+    for y := tile.bounds.Min.Y; y < tile.bounds.Max.Y; y++ {
+        for x := tile.bounds.Min.X; x < tile.bounds.Max.X; x++ {
+            if !renderer.needsRefinement(initial, x, y) {
+                continue
+            }
+            color, samples := renderer.refine(float64(x), float64(y), 1, initial.At(x, y), renderer.options.AdaptiveDepth, &stats)
+            framebuffer.set(x, y, color)
+            stats.RefinedPixels++
+            stats.ExtraSamples += samples
+        }
+    }
+    renderer.stats.add(&stats)
+}
+
+// Averages the four quadrants of the square at (x, y), each traced at its
+// center and split again while it differs from the square's color and depth
+// remains. Returns the color and the number of samples taken.
+func (renderer *Renderer) refine(x float64, y float64, size float64, color raytracer.Vector, depth int, stats *RenderStats) (raytracer.Vector, int64) {
+    half := size/2
+    sum := emptyVector()
+    samples := int64(0)
+    for i := 0; i < 4; i++ {
+        quadrantX, quadrantY := x + float64(i%2)*half, y + float64(i/2)*half
+        quadrantColor := renderer.trace(quadrantX + half/2, quadrantY + half/2, stats)
+        samples++
+        if depth > 1 && contrast(quadrantColor, color) > renderer.options.AdaptiveThreshold {
+            var quadrantSamples int64
+            quadrantColor, quadrantSamples = renderer.refine(quadrantX, quadrantY, half, quadrantColor, depth - 1, stats)
+            samples += quadrantSamples
+        }
+        sum = sum.VectorAdd(quadrantColor)
+    }
+    return sum.VectorDiv(4), samples
+}
@@ -0,0 +1,356 @@
+package tracer
+
+import (
+    "math"
+    "../vector"
+)
+
+const (
+    BVH_BINS = 12
+    BVH_MAX_LEAF_SIZE = 4
+    // Cost of visiting a node relative to intersecting one shape
+    BVH_TRAVERSAL_COST = 0.125
+)
+
+// Axis aligned bounding box in world space
+type AABB struct {
+    min raytracer.Vector
+    max raytracer.Vector
+}
+
+type bvhPrimitive struct {
+    shape Shape
+    bounds AABB
+    centroid raytracer.Vector
+}
+
+// Nodes are stored depth first, so the first child of an interior node is
+// always the next node in the slice.
+type bvhNode struct {
+    bounds AABB
+    axis int
+    secondChild int
+    firstShape int
+    shapeCount int
+}
+
+// BVH is a bounding volume hierarchy over every shape in a scene, built
+// with the surface area heuristic.
+type BVH struct {
+    nodes []bvhNode
+    shapes []Shape
+}
+
+func emptyAABB() AABB {
+    inf := math.Inf(1)
+    return AABB{
+        min: raytracer.Vector{X:inf, Y:inf, Z:inf},
+        max: raytracer.Vector{X:-inf, Y:-inf, Z:-inf},
+    }
+}
+
+func component(v raytracer.Vector, axis int) float64 {
+    switch axis {
+    case 0:
+        return v.X
+    case 1:
+        return v.Y
+    }
+    return v.Z
+}
+
+func (box AABB) extend(point raytracer.Vector) AABB {
+    return AABB{
+        min: raytracer.Vector{X:math.Min(box.min.X, point.X), Y:math.Min(box.min.Y, point.Y), Z:math.Min(box.min.Z, point.Z)},
+        max: raytracer.Vector{X:math.Max(box.max.X, point.X), Y:math.Max(box.max.Y, point.Y), Z:math.Max(box.max.Z, point.Z)},
+    }
+}
+
+func (box AABB) union(other AABB) AABB {
+    return AABB{
+        min: raytracer.Vector{X:math.Min(box.min.X, other.min.X), Y:math.Min(box.min.Y, other.min.Y), Z:math.Min(box.min.Z, other.min.Z)},
+        max: raytracer.Vector{X:math.Max(box.max.X, other.max.X), Y:math.Max(box.max.Y, other.max.Y), Z:math.Max(box.max.Z, other.max.Z)},
+    }
+}
+
+func (box AABB) centroid() raytracer.Vector {
+    return box.min.VectorAdd(box.max).VectorScale(0.5)
+}
+
+func (box AABB) surfaceArea() float64 {
+    size := box.max.VectorSub(box.min)
+    if size.X < 0 || size.Y < 0 || size.Z < 0 {
+        return 0
+    }
+    return 2*(size.X*size.Y + size.Y*size.Z + size.Z*size.X)
+}
+
+// Bounds of the box once its corners are transformed by the matrix
+func (box AABB) transform(matrix raytracer.Matrix4) AABB {
+    transformed := emptyAABB()
+    for i := 0; i < 8; i++ {
+        corner := box.min
+        if i&1 != 0 {
+            corner.X = box.max.X
+        }
+        if i&2 != 0 {
+            corner.Y = box.max.Y
+        }
+        if i&4 != 0 {
+            corner.Z = box.max.Z
+        }
+        transformed = transformed.extend(matrix.TransformPoint(corner))
+    }
+    return transformed
+}
+
+// Slab test against the part of the ray between tMin and tMax
+func (box AABB) intersects(ray Ray, inverseDirection raytracer.Vector, tMin float64, tMax float64) bool {
+    for axis := 0; axis < 3; axis++ {
+        start := component(ray.start, axis)
+        low := component(box.min, axis)
+        high := component(box.max, axis)
+        if component(ray.direction, axis) == 0 {
+            if start < low || start > high {
+                return false
+            }
+            continue
+        }
+        inverse := component(inverseDirection, axis)
+        tNear := (low - start)*inverse
+        tFar := (high - start)*inverse
+        if tNear > tFar {
+            tNear, tFar = tFar, tNear
+        }
+        tMin = math.Max(tMin, tNear)
+        tMax = math.Min(tMax, tFar)
+        if tMin > tMax {
+            return false
+        }
+    }
+    return true
+}
+
+func buildBVH(scene *Scene) *BVH {
+    primitives := make([]bvhPrimitive, 0, len(scene.shapes))
+    for _, shape := range scene.shapes {
+        bounds := shape.bounds()
+        primitives = append(primitives, bvhPrimitive{shape: shape, bounds: bounds, centroid: bounds.centroid()})
+    }
+    bvh := &BVH{shapes: make([]Shape, 0, len(primitives))}
+    if len(primitives) > 0 {
+        bvh.build(primitives)
+    }
+    return bvh
+}
+
+func (bvh *BVH) build(primitives []bvhPrimitive) int {
+    index := len(bvh.nodes)
+    bvh.nodes = append(bvh.nodes, bvhNode{})
+
+    bounds := emptyAABB()
+    centroidBounds := emptyAABB()
+    for _, primitive := range primitives {
+        bounds = bounds.union(primitive.bounds)
+        centroidBounds = centroidBounds.extend(primitive.centroid)
+    }
+
+    axis, mid := -1, 0
+    if len(primitives) > BVH_MAX_LEAF_SIZE {
+        axis, mid = splitPrimitives(primitives, bounds, centroidBounds)
+    }
+    if axis == -1 {
+        bvh.nodes[index] = bvhNode{bounds: bounds, firstShape: len(bvh.shapes), shapeCount: len(primitives)}
+        for _, primitive := range primitives {
+            bvh.shapes = append(bvh.shapes, primitive.shape)
+        }
+        return index
+    }
+
+    bvh.build(primitives[:mid])
+    secondChild := bvh.build(primitives[mid:])
+    bvh.nodes[index] = bvhNode{bounds: bounds, axis: axis, secondChild: secondChild}
+    return index
+}
+
+// Picks the cheapest binned SAH split and partitions the primitives around it.
+// Returns -1 as the axis when a leaf is cheaper than any split.
+func splitPrimitives(primitives []bvhPrimitive, bounds AABB, centroidBounds AABB) (int, int) {
+    bestAxis, bestBin := -1, 0
+    bestCost := float64(len(primitives))
+    area := bounds.surfaceArea()
+
+    for axis := 0; axis < 3; axis++ {
+        low := component(centroidBounds.min, axis)
+        extent := component(centroidBounds.max, axis) - low
+        if extent <= 0 {
+            continue
+        }
+        var counts [BVH_BINS]int
+        var binBounds [BVH_BINS]AABB
+        for i := range binBounds {
+            binBounds[i] = emptyAABB()
+        }
+        for _, primitive := range primitives {
+            bin := binIndex(component(primitive.centroid, axis), low, extent)
+            counts[bin]++
+            binBounds[bin] = binBounds[bin].union(primitive.bounds)
+        }
+
+        // Sweep from the right so each split's right side cost is known
+        var rightAreas [BVH_BINS]float64
+        var rightCounts [BVH_BINS]int
+        rightBounds := emptyAABB()
+        rightCount := 0
+        for bin := BVH_BINS - 1; bin > 0; bin-- {
+            rightBounds = rightBounds.union(binBounds[bin])
+            rightCount += counts[bin]
+            rightAreas[bin] = rightBounds.surfaceArea()
+            rightCounts[bin] = rightCount
+        }
+
+        leftBounds := emptyAABB()
+        leftCount := 0
+        for bin := 1; bin < BVH_BINS; bin++ {
+            leftBounds = leftBounds.union(binBounds[bin-1])
+            leftCount += counts[bin-1]
+            if leftCount == 0 || rightCounts[bin] == 0 {
+                continue
+            }
+            cost := BVH_TRAVERSAL_COST
+            if area > 0 {
+                cost += (leftBounds.surfaceArea()*float64(leftCount) + rightAreas[bin]*float64(rightCounts[bin]))/area
+            } else {
+                cost += float64(len(primitives))
+            }
+            if cost < bestCost {
+                bestAxis, bestBin, bestCost = axis, bin, cost
+            }
+        }
+    }
+
+    if bestAxis == -1 {
+        return -1, 0
+    }
+
+    low := component(centroidBounds.min, bestAxis)
+    extent := component(centroidBounds.max, bestAxis) - low
+    mid := 0
+    for i := range primitives {
+        if binIndex(component(primitives[i].centroid, bestAxis), low, extent) < bestBin {
+            primitives[i], primitives[mid] = primitives[mid], primitives[i]
+            mid++
+        }
+    }
+    return bestAxis, mid
+}
+
+func binIndex(value float64, low float64, extent float64) int {
+    bin := int(BVH_BINS*(value - low)/extent)
+    if bin >= BVH_BINS {
+        bin = BVH_BINS - 1
+    }
+    if bin < 0 {
+        bin = 0
+    }
+    return bin
+}
+
+func inverseDirection(direction raytracer.Vector) raytracer.Vector {
+    return raytracer.Vector{X:1/direction.X, Y:1/direction.Y, Z:1/direction.Z}
+}
+
+// Nearest hit with tMin < t < tMax, skipping the shape with ID exclude
+func (bvh *BVH) closestHit(ray Ray, tMin float64, tMax float64, exclude int) (Hit, bool) {
+    var closest Hit
+    isHit := false
+    if len(bvh.nodes) == 0 {
+        return closest, false
+    }
+
+    inverse := inverseDirection(ray.direction)
+    var stackStorage [64]int
+    stack := append(stackStorage[:0], 0)
+    for len(stack) > 0 {
+        index := stack[len(stack)-1]
+        stack = stack[:len(stack)-1]
+        node := &bvh.nodes[index]
+        if !node.bounds.intersects(ray, inverse, tMin, tMax) {
+            continue
+        }
+        if node.shapeCount > 0 {
+            for _, shape := range bvh.shapes[node.firstShape:node.firstShape+node.shapeCount] {
+                if shape.ID() == exclude {
+                    continue
+                }
+                // Each hit narrows the range for the rest
+                if hit, ok := shape.Intersect(ray, tMin, tMax); ok {
+                    closest, isHit, tMax = hit, true, hit.t
+                }
+            }
+            continue
+        }
+        // Visit the child on the near side of the split first
+        if component(ray.direction, node.axis) < 0 {
+            stack = append(stack, index+1, node.secondChild)
+        } else {
+            stack = append(stack, node.secondChild, index+1)
+        }
+    }
+    return closest, isHit
+}
+
+// Product of the transmission of the shapes the ray passes with
+// tMin < t < tMax, zero as soon as an opaque one blocks it. Each shape tints
+// the ray once however many times the ray crosses it, and so does each
+// material of a mesh, so a closed glass mesh casts the shadow a glass
+// sphere does.
+func (bvh *BVH) transmittance(ray Ray, tMin float64, tMax float64) raytracer.Vector {
+    transmittance := raytracer.Vector{X:1, Y:1, Z:1}
+    if len(bvh.nodes) == 0 {
+        return transmittance
+    }
+    type meshMaterial struct {
+        mesh *Mesh
+        material *Material
+    }
+    // Made on the first transparent triangle, most shadow rays meet none
+    var tinted map[meshMaterial]bool
+
+    inverse := inverseDirection(ray.direction)
+    var stackStorage [64]int
+    stack := append(stackStorage[:0], 0)
+    for len(stack) > 0 {
+        index := stack[len(stack)-1]
+        stack = stack[:len(stack)-1]
+        node := &bvh.nodes[index]
+        if !node.bounds.intersects(ray, inverse, tMin, tMax) {
+            continue
+        }
+        if node.shapeCount > 0 {
+            for _, shape := range bvh.shapes[node.firstShape:node.firstShape+node.shapeCount] {
+                hit, ok := shape.Intersect(ray, tMin, tMax)
+                if !ok {
+                    continue
+                }
+                if !hit.material.isTransparent() {
+                    return emptyVector()
+                }
+                if triangle, ok := shape.(Triangle); ok {
+                    key := meshMaterial{mesh: triangle.mesh, material: hit.material}
+                    if tinted[key] {
+                        continue
+                    }
+                    if tinted == nil {
+                        tinted = map[meshMaterial]bool{}
+                    }
+                    tinted[key] = true
+                }
+                transmittance = transmittance.VectorMult(hit.material.transmission)
+            }
+            continue
+        }
+        stack = append(stack, node.secondChild, index+1)
+    }
+    return transmittance
+}
@@ -0,0 +1,839 @@
+package tracer
+
+import (
+    "bytes"
+    "encoding/base64"
+    "encoding/binary"
+    "encoding/json"
+    "fmt"
+    "math"
+    "net/url"
+    "os"
+    "strings"
+    "../vector"
+)
+
+// The parts of a glTF 2.0 document the renderer uses. Field names match
+// the JSON keys case insensitively.
+type gltfDocument struct {
+    Asset struct {
+        Version string
+    }
+    ExtensionsRequired []string
+    Scene *int
+    Scenes []struct {
+        Nodes []int
+    }
+    Nodes []gltfNode
+    Meshes []gltfMesh
+    Materials []gltfMaterial
+    Textures []struct {
+        Source *int
+    }
+    Images []struct {
+        URI string
+        BufferView *int
+    }
+    Cameras []gltfCamera
+    Accessors []gltfAccessor
+    BufferViews []struct {
+        Buffer int
+        ByteOffset int
+        ByteLength int
+        ByteStride int
+    }
+    Buffers []struct {
+        URI string
+        ByteLength int
+    }
+    Extensions struct {
+        LightsPunctual struct {
+            Lights []gltfLight
+        } `json:"KHR_lights_punctual"`
+    }
+}
+
+type gltfNode struct {
+    Children []int
+    // Column major, replaces translation, rotation and scale when given
+    Matrix []float64
+    Translation []float64
+    // Quaternion as x, y, z, w
+    Rotation []float64
+    Scale []float64
+    Mesh *int
+    Camera *int
+    Extensions struct {
+        LightsPunctual *struct {
+            Light int
+        } `json:"KHR_lights_punctual"`
+    }
+}
+
+type gltfMesh struct {
+    Name string
+    Primitives []struct {
+        Attributes map[string]int
+        Indices *int
+        Material *int
+        Mode *int
+    }
+}
+
+type gltfMaterial struct {
+    PbrMetallicRoughness *struct {
+        BaseColorFactor []float64
+        BaseColorTexture *struct {
+            Index int
+        }
+        MetallicFactor *float64
+        RoughnessFactor *float64
+    }
+    AlphaMode string
+    Extensions struct {
+        Transmission *struct {
+            TransmissionFactor float64
+        } `json:"KHR_materials_transmission"`
+        IOR *struct {
+            IOR *float64
+        } `json:"KHR_materials_ior"`
+    }
+}
+
+type gltfCamera struct {
+    Type string
+    Perspective struct {
+        AspectRatio float64
+        Yfov float64
+    }
+}
+
+type gltfLight struct {
+    Type string
+    Color []float64
+    Intensity *float64
+}
+
+type gltfAccessor struct {
+    BufferView *int
+    ByteOffset int
+    ComponentType int
+    Normalized bool
+    Count int
+    Type string
+    Sparse json.RawMessage
+}
+
+// Bytes per component by componentType, and components per element
+var gltfComponentSizes = map[int]int{5120: 1, 5121: 1, 5122: 2, 5123: 2, 5125: 4, 5126: 4}
+var gltfTypeSizes = map[string]int{"SCALAR": 1, "VEC2": 2, "VEC3": 3, "VEC4": 4, "MAT4": 16}
+
+// Extensions a file may require that the importer understands
+var gltfExtensions = map[string]bool{"KHR_lights_punctual": true, "KHR_materials_transmission": true, "KHR_materials_ior": true}
+
+// Reads one glTF file, converting its meshes, materials and textures once
+// however many nodes use them
+type gltfImporter struct {
+    filename string
+    document gltfDocument
+    buffers [][]byte
+    // Material of primitives without one
+    fallback *Material
+    materials map[int]*Material
+    textures map[int]*Texture
+    meshes map[int]*Mesh
+}
+
+// Adds the meshes of a .gltf or .glb file's default scene to the scene,
+// placed by their nodes under the transformation. With whole set the file
+// is the entire scene and its first camera and its lights are added too.
+// Primitives without a material use the given one.
+func (scene *Scene) importGltf(filename string, transform Transform, material *Material, whole bool) error {
+    importer, err := readGltf(filename)
+    if err != nil {
+        return err
+    }
+    importer.fallback = material
+    document := &importer.document
+
+    roots := []int{}
+    if len(document.Scenes) > 0 {
+        index := 0
+        if document.Scene != nil {
+            index = *document.Scene
+        }
+        if index < 0 || index >= len(document.Scenes) {
+            return fmt.Errorf("%s: scene %d does not exist", filename, index)
+        }
+        roots = document.Scenes[index].Nodes
+    } else {
+        // Without scenes every node that is not a child is drawn
+        isChild := make([]bool, len(document.Nodes))
+        for _, node := range document.Nodes {
+            for _, child := range node.Children {
+                if child >= 0 && child < len(isChild) {
+                    isChild[child] = true
+                }
+            }
+        }
+        for i := range document.Nodes {
+            if !isChild[i] {
+                roots = append(roots, i)
+            }
+        }
+    }
+
+    hasCamera := false
+    var visit func(index int, parent raytracer.Matrix4, depth int) error
+    visit = func(index int, parent raytracer.Matrix4, depth int) error {
+        if index < 0 || index >= len(document.Nodes) {
+            return fmt.Errorf("%s: node %d does not exist", filename, index)
+        }
+        if depth > len(document.Nodes) {
+            return fmt.Errorf("%s: node %d is its own ancestor", filename, index)
+        }
+        node := &document.Nodes[index]
+        local, err := node.matrix()
+        if err != nil {
+            return fmt.Errorf("%s: node %d: %v", filename, index, err)
+        }
+        // In file units, scaled by SCALE_FACTOR where it reaches the scene
+        world := parent.Multiply(local)
+
+        if node.Mesh != nil {
+            mesh, err := importer.mesh(*node.Mesh)
+            if err != nil {
+                return err
+            }
+            scale := raytracer.Scaling(raytracer.Vector{X: SCALE_FACTOR, Y: SCALE_FACTOR, Z: SCALE_FACTOR})
+            unscale := raytracer.Scaling(raytracer.Vector{X: 1/SCALE_FACTOR, Y: 1/SCALE_FACTOR, Z: 1/SCALE_FACTOR})
+            // The node places the mesh before the scene's transformation
+            // does. Nodes scaled to nothing are left out, they cover no
+            // pixels.
+            if nodeTransform, ok := newTransform(transform.objectToWorld.Multiply(scale).Multiply(world).Multiply(unscale)); ok {
+                // Shares the buffers, the mesh is reported once for all nodes
+                instance := *mesh
+                instance.transform, instance.instanceOf, instance.instances = nodeTransform, mesh, 0
+                scene.addMesh(&instance)
+            }
+        }
+        if whole && node.Camera != nil && !hasCamera {
+            if err := scene.setGltfCamera(importer, *node.Camera, world); err != nil {
+                return err
+            }
+            hasCamera = true
+        }
+        if whole && node.Extensions.LightsPunctual != nil {
+            if err := scene.addGltfLight(importer, node.Extensions.LightsPunctual.Light, world); err != nil {
+                return err
+            }
+        }
+        for _, child := range node.Children {
+            if err := visit(child, world, depth + 1); err != nil {
+                return err
+            }
+        }
+        return nil
+    }
+    for _, root := range roots {
+        if err := visit(root, raytracer.Identity(), 0); err != nil {
+            return err
+        }
+    }
+    if whole && !hasCamera {
+        return fmt.Errorf("%s: the scene has no camera", filename)
+    }
+    return nil
+}
+
+// Loads a .gltf or .glb file as a whole scene. Primitives without a
+// material are plain white.
+func parseGltfScene(filename string) (*Scene, error) {
+    scene := newScene()
+    material := &Material{diffuse: raytracer.Vector{X: 1, Y: 1, Z: 1}, ior: 1}
+    if err := scene.importGltf(filename, identityTransform(), material, true); err != nil {
+        return nil, err
+    }
+    scene.bvh = buildBVH(scene)
+    return scene, nil
+}
+
+// Reads the JSON, from a .glb container when the file starts with its
+// magic, and every buffer
+func readGltf(filename string) (*gltfImporter, error) {
+    contents, err := os.ReadFile(filename)
+    if err != nil {
+        return nil, err
+    }
+    var binaryChunk []byte
+    if bytes.HasPrefix(contents, []byte("glTF")) {
+        if contents, binaryChunk, err = splitGlb(contents); err != nil {
+            return nil, fmt.Errorf("%s: %v", filename, err)
+        }
+    }
+
+    importer := &gltfImporter{filename: filename, materials: map[int]*Material{}, textures: map[int]*Texture{}, meshes: map[int]*Mesh{}}
+    if err := json.Unmarshal(contents, &importer.document); err != nil {
+        return nil, fmt.Errorf("%s: %v", filename, err)
+    }
+    document := &importer.document
+    if !strings.HasPrefix(document.Asset.Version, "2.") {
+        return nil, fmt.Errorf("%s: glTF version %q, expected 2.0", filename, document.Asset.Version)
+    }
+    for _, extension := range document.ExtensionsRequired {
+        if !gltfExtensions[extension] {
+            return nil, fmt.Errorf("%s: requires unsupported extension %s", filename, extension)
+        }
+    }
+
+    for i, buffer := range document.Buffers {
+        var data []byte
+        switch {
+        case buffer.URI == "":
+            // Only the first buffer of a .glb may leave out its URI
+            if i != 0 || binaryChunk == nil {
+                return nil, fmt.Errorf("%s: buffer %d has no uri", filename, i)
+            }
+            data = binaryChunk
+        default:
+            if data, err = importer.readURI(buffer.URI); err != nil {
+                return nil, fmt.Errorf("%s: buffer %d: %v", filename, i, err)
+            }
+        }
+        if len(data) < buffer.ByteLength {
+            return nil, fmt.Errorf("%s: buffer %d has %d bytes, expected %d", filename, i, len(data), buffer.ByteLength)
+        }
+        importer.buffers = append(importer.buffers, data)
+    }
+    return importer, nil
+}
+
+// A .glb file is a 12 byte header followed by a JSON chunk and an optional
+// binary chunk, each with its length and type before it
+func splitGlb(contents []byte) ([]byte, []byte, error) {
+    if len(contents) < 20 || binary.LittleEndian.Uint32(contents[4:]) != 2 {
+        return nil, nil, fmt.Errorf("not a version 2 .glb file")
+    }
+    var jsonChunk, binaryChunk []byte
+    for offset := 12; offset + 8 <= len(contents); {
+        length := int(binary.LittleEndian.Uint32(contents[offset:]))
+        chunkType := string(contents[offset + 4:offset + 8])
+        offset += 8
+        if length > len(contents) - offset {
+            return nil, nil, fmt.Errorf("chunk %q is truncated", chunkType)
+        }
+        chunk := contents[offset:offset + length]
+        switch {
+        case chunkType == "JSON" && jsonChunk == nil:
+            jsonChunk = chunk
+        case chunkType == "BIN\x00" && binaryChunk == nil:
+            binaryChunk = chunk
+        }
+        offset += length
+    }
+    if jsonChunk == nil {
+        return nil, nil, fmt.Errorf(".glb file has no JSON chunk")
+    }
+    return jsonChunk, binaryChunk, nil
+}
+
+// Contents of a base64 data URI, or of a file relative to the glTF file
+func (importer *gltfImporter) readURI(uri string) ([]byte, error) {
+    if strings.HasPrefix(uri, "data:") {
+        comma := strings.Index(uri, ",")
+        if comma == -1 || !strings.HasSuffix(uri[:comma], ";base64") {
+            return nil, fmt.Errorf("data uri is not base64")
+        }
+        return base64.StdEncoding.DecodeString(uri[comma + 1:])
+    }
+    path, err := url.PathUnescape(uri)
+    if err != nil {
+        return nil, err
+    }
+    return os.ReadFile(resolvePath(importer.filename, path))
+}
+
+// Local transformation of the node, translation * rotation * scale unless
+// a matrix is given
+func (node *gltfNode) matrix() (raytracer.Matrix4, error) {
+    if node.Matrix != nil {
+        if len(node.Matrix) != 16 {
+            return raytracer.Matrix4{}, fmt.Errorf("matrix has %d values, expected 16", len(node.Matrix))
+        }
+        matrix := raytracer.Matrix4{}
+        for column := 0; column < 4; column++ {
+            for row := 0; row < 4; row++ {
+                matrix[row][column] = node.Matrix[column*4 + row]
+            }
+        }
+        return matrix, nil
+    }
+
+    matrix := raytracer.Identity()
+    if node.Translation != nil {
+        if len(node.Translation) != 3 {
+            return matrix, fmt.Errorf("translation has %d values, expected 3", len(node.Translation))
+        }
+        matrix = raytracer.Translation(raytracer.Vector{X: node.Translation[0], Y: node.Translation[1], Z: node.Translation[2]})
+    }
+    if node.Rotation != nil {
+        if len(node.Rotation) != 4 {
+            return matrix, fmt.Errorf("rotation has %d values, expected 4", len(node.Rotation))
+        }
+        matrix = matrix.Multiply(quaternionMatrix(node.Rotation[0], node.Rotation[1], node.Rotation[2], node.Rotation[3]))
+    }
+    if node.Scale != nil {
+        if len(node.Scale) != 3 {
+            return matrix, fmt.Errorf("scale has %d values, expected 3", len(node.Scale))
+        }
+        matrix = matrix.Multiply(raytracer.Scaling(raytracer.Vector{X: node.Scale[0], Y: node.Scale[1], Z: node.Scale[2]}))
+    }
+    return matrix, nil
+}
+
+// Rotation by the quaternion, normalized first
+func quaternionMatrix(x float64, y float64, z float64, w float64) raytracer.Matrix4 {
+    length := math.Sqrt(x*x + y*y + z*z + w*w)
+    if length == 0 {
+        return raytracer.Identity()
+    }
+    x, y, z, w = x/length, y/length, z/length, w/length
+    return raytracer.Matrix4{
+        {1 - 2*(y*y + z*z), 2*(x*y - z*w), 2*(x*z + y*w), 0},
+        {2*(x*y + z*w), 1 - 2*(x*x + z*z), 2*(y*z - x*w), 0},
+        {2*(x*z - y*w), 2*(y*z + x*w), 1 - 2*(x*x + y*y), 0},
+        {0, 0, 0, 1},
+    }
+}
+
+// Elements of an accessor as float64s, components per element apart.
+// Normalized integers are mapped to [0, 1] or [-1, 1].
+func (importer *gltfImporter) accessor(index int) ([]float64, int, error) {
+    document := &importer.document
+    if index < 0 || index >= len(document.Accessors) {
+        return nil, 0, fmt.Errorf("%s: accessor %d does not exist", importer.filename, index)
+    }
+    accessor := &document.Accessors[index]
+    accessorError := func(format string, a ...interface{}) error {
+        return fmt.Errorf("%s: accessor %d: %s", importer.filename, index, fmt.Sprintf(format, a...))
+    }
+    size, ok := gltfComponentSizes[accessor.ComponentType]
+    if !ok {
+        return nil, 0, accessorError("unknown component type %d", accessor.ComponentType)
+    }
+    components, ok := gltfTypeSizes[accessor.Type]
+    if !ok {
+        return nil, 0, accessorError("unsupported type %q", accessor.Type)
+    }
+    if accessor.Sparse != nil {
+        return nil, 0, accessorError("sparse accessors are not supported")
+    }
+    values := make([]float64, accessor.Count*components)
+    // Accessors without a buffer view are all zeros
+    if accessor.BufferView == nil || accessor.Count == 0 {
+        return values, components, nil
+    }
+
+    if *accessor.BufferView < 0 || *accessor.BufferView >= len(document.BufferViews) {
+        return nil, 0, accessorError("buffer view %d does not exist", *accessor.BufferView)
+    }
+    view := document.BufferViews[*accessor.BufferView]
+    if view.Buffer < 0 || view.Buffer >= len(importer.buffers) || view.ByteOffset < 0 || view.ByteLength < 0 ||
+        view.ByteOffset + view.ByteLength > len(importer.buffers[view.Buffer]) {
+        return nil, 0, accessorError("buffer view %d is outside its buffer", *accessor.BufferView)
+    }
+    data := importer.buffers[view.Buffer][view.ByteOffset:view.ByteOffset + view.ByteLength]
+    stride := view.ByteStride
+    if stride == 0 {
+        stride = size*components
+    }
+    if accessor.Count < 0 || accessor.ByteOffset < 0 || accessor.ByteOffset + stride*(accessor.Count - 1) + size*components > len(data) {
+        return nil, 0, accessorError("%d elements do not fit in buffer view %d", accessor.Count, *accessor.BufferView)
+    }
+
+    for i := 0; i < accessor.Count; i++ {
+        for j := 0; j < components; j++ {
+            element := data[accessor.ByteOffset + i*stride + j*size:]
+            var value float64
+            switch accessor.ComponentType {
+            case 5120:
+                value = float64(int8(element[0]))
+                if accessor.Normalized {
+                    value = math.Max(value/127, -1)
+                }
+            case 5121:
+                value = float64(element[0])
+                if accessor.Normalized {
+                    value /= 255
+                }
+            case 5122:
+                value = float64(int16(binary.LittleEndian.Uint16(element)))
+                if accessor.Normalized {
+                    value = math.Max(value/32767, -1)
+                }
+            case 5123:
+                value = float64(binary.LittleEndian.Uint16(element))
+                if accessor.Normalized {
+                    value /= 65535
+                }
+            case 5125:
+                value = float64(binary.LittleEndian.Uint32(element))
+            case 5126:
+                value = float64(math.Float32frombits(binary.LittleEndian.Uint32(element)))
+            }
+            values[i*components + j] = value
+        }
+    }
+    return values, components, nil
+}
+
+// The glTF mesh as one Mesh with a material per primitive, without its
+// node's transformation. Only triangle primitives are kept. Primitives
+// without normals are flat shaded, as the format asks.
+func (importer *gltfImporter) mesh(index int) (*Mesh, error) {
+    if mesh, ok := importer.meshes[index]; ok {
+        return mesh, nil
+    }
+    document := &importer.document
+    if index < 0 || index >= len(document.Meshes) {
+        return nil, fmt.Errorf("%s: mesh %d does not exist", importer.filename, index)
+    }
+    source := document.Meshes[index]
+    name := source.Name
+    if name == "" {
+        name = fmt.Sprintf("mesh %d", index)
+    }
+    mesh := &Mesh{name: fmt.Sprintf("%s (%s)", importer.filename, name), material: importer.fallback}
+    meshError := func(primitive int, format string, a ...interface{}) error {
+        return fmt.Errorf("%s: %s primitive %d: %s", importer.filename, name, primitive, fmt.Sprintf(format, a...))
+    }
+    // Indices in mesh.materials by glTF material
+    used := map[int]int32{}
+    // The diffuse color for the vertices of primitives without colors
+    colors := []raytracer.Vector{}
+    hasColors := false
+
+    for p, primitive := range source.Primitives {
+        mode := 4
+        if primitive.Mode != nil {
+            mode = *primitive.Mode
+        }
+        // Points and lines have no area to hit
+        if mode < 4 || mode > 6 {
+            continue
+        }
+        positionAccessor, ok := primitive.Attributes["POSITION"]
+        if !ok {
+            return nil, meshError(p, "no POSITION attribute")
+        }
+        positions, components, err := importer.accessor(positionAccessor)
+        if err != nil {
+            return nil, err
+        }
+        if components != 3 {
+            return nil, meshError(p, "POSITION is not VEC3")
+        }
+        count := len(positions)/3
+        base := len(mesh.positions)
+        for i := 0; i < count; i++ {
+            mesh.positions = append(mesh.positions, raytracer.Vector{X: positions[3*i], Y: positions[3*i + 1], Z: positions[3*i + 2]}.VectorScale(SCALE_FACTOR))
+        }
+
+        normalBase, texcoordBase := -1, -1
+        if accessor, ok := primitive.Attributes["NORMAL"]; ok {
+            normals, components, err := importer.accessor(accessor)
+            if err != nil {
+                return nil, err
+            }
+            if components != 3 || len(normals) != len(positions) {
+                return nil, meshError(p, "NORMAL is not a VEC3 per vertex")
+            }
+            normalBase = len(mesh.normals)
+            for i := 0; i < count; i++ {
+                mesh.normals = append(mesh.normals, raytracer.Vector{X: normals[3*i], Y: normals[3*i + 1], Z: normals[3*i + 2]}.Normalize())
+            }
+        }
+        if accessor, ok := primitive.Attributes["TEXCOORD_0"]; ok {
+            texcoords, components, err := importer.accessor(accessor)
+            if err != nil {
+                return nil, err
+            }
+            if components != 2 || len(texcoords) != 2*count {
+                return nil, meshError(p, "TEXCOORD_0 is not a VEC2 per vertex")
+            }
+            texcoordBase = len(mesh.texcoords)
+            for i := 0; i < count; i++ {
+                // glTF puts v = 0 at the top of the image
+                mesh.texcoords = append(mesh.texcoords, [2]float64{texcoords[2*i], 1 - texcoords[2*i + 1]})
+            }
+        }
+        material := int32(-1)
+        if primitive.Material != nil {
+            if index, ok := used[*primitive.Material]; ok {
+                material = index
+            } else {
+                converted, err := importer.material(*primitive.Material)
+                if err != nil {
+                    return nil, err
+                }
+                material = int32(len(mesh.materials))
+                used[*primitive.Material] = material
+                mesh.materials = append(mesh.materials, converted)
+            }
+        }
+        // Vertex colors replace the diffuse color when shading, so they carry
+        // the base color glTF multiplies them by
+        diffuse := mesh.material.diffuse
+        if material >= 0 {
+            diffuse = mesh.materials[material].diffuse
+        }
+        for i := 0; i < count; i++ {
+            colors = append(colors, diffuse)
+        }
+        if accessor, ok := primitive.Attributes["COLOR_0"]; ok {
+            values, components, err := importer.accessor(accessor)
+            if err != nil {
+                return nil, err
+            }
+            if (components != 3 && components != 4) || len(values) != components*count {
+                return nil, meshError(p, "COLOR_0 is not a VEC3 or VEC4 per vertex")
+            }
+            for i := 0; i < count; i++ {
+                colors[base + i] = diffuse.VectorMult(raytracer.Vector{X: values[components*i], Y: values[components*i + 1], Z: values[components*i + 2]})
+            }
+            hasColors = true
+        }
+
+        indices := make([]int, count)
+        for i := range indices {
+            indices[i] = i
+        }
+        if primitive.Indices != nil {
+            values, components, err := importer.accessor(*primitive.Indices)
+            if err != nil {
+                return nil, err
+            }
+            if components != 1 {
+                return nil, meshError(p, "indices are not SCALAR")
+            }
+            indices = make([]int, len(values))
+            for i, value := range values {
+                if value < 0 || int(value) >= count {
+                    return nil, meshError(p, "vertex index %d out of range", int(value))
+                }
+                indices[i] = int(value)
+            }
+        }
+
+        vertex := func(i int) MeshVertex {
+            vertex := flatVertex(base + indices[i])
+            if normalBase >= 0 {
+                vertex.normal = int32(normalBase + indices[i])
+            }
+            if texcoordBase >= 0 {
+                vertex.texcoord = int32(texcoordBase + indices[i])
+            }
+            return vertex
+        }
+        addTriangle := func(a int, b int, c int) {
+            mesh.triangles = append(mesh.triangles, [3]MeshVertex{vertex(a), vertex(b), vertex(c)})
+            mesh.triangleMaterials = append(mesh.triangleMaterials, material)
+        }
+        switch mode {
+        case 4:
+            for i := 0; i + 2 < len(indices); i += 3 {
+                addTriangle(i, i + 1, i + 2)
+            }
+        case 5:
+            // Every other triangle of a strip is wound the other way
+            for i := 0; i + 2 < len(indices); i++ {
+                if i%2 == 0 {
+                    addTriangle(i, i + 1, i + 2)
+                } else {
+                    addTriangle(i + 1, i, i + 2)
+                }
+            }
+        case 6:
+            for i := 1; i + 1 < len(indices); i++ {
+                addTriangle(0, i, i + 1)
+            }
+        }
+    }
+    if hasColors {
+        mesh.colors = colors
+    }
+    if len(mesh.materials) == 0 {
+        mesh.triangleMaterials = nil
+    }
+    importer.meshes[index] = mesh
+    return mesh, nil
+}
+
+// Approximates a metallic-roughness material with Phong terms. Metals
+// color their highlights and reflections instead of their diffuse light,
+// rough surfaces have wide highlights and smooth ones reflect. The
+// transmission extension and blended transparency make it refract.
+func (importer *gltfImporter) material(index int) (*Material, error) {
+    if material, ok := importer.materials[index]; ok {
+        return material, nil
+    }
+    document := &importer.document
+    if index < 0 || index >= len(document.Materials) {
+        return nil, fmt.Errorf("%s: material %d does not exist", importer.filename, index)
+    }
+    source := document.Materials[index]
+    baseColor := [4]float64{1, 1, 1, 1}
+    metallic, roughness := 1.0, 1.0
+    var texture *Texture
+    if pbr := source.PbrMetallicRoughness; pbr != nil {
+        if pbr.BaseColorFactor != nil {
+            if len(pbr.BaseColorFactor) != 4 {
+                return nil, fmt.Errorf("%s: material %d: baseColorFactor has %d values, expected 4", importer.filename, index, len(pbr.BaseColorFactor))
+            }
+            copy(baseColor[:], pbr.BaseColorFactor)
+        }
+        if pbr.MetallicFactor != nil {
+            metallic = *pbr.MetallicFactor
+        }
+        if pbr.RoughnessFactor != nil {
+            roughness = *pbr.RoughnessFactor
+        }
+        if pbr.BaseColorTexture != nil {
+            var err error
+            if texture, err = importer.texture(pbr.BaseColorTexture.Index); err != nil {
+                return nil, err
+            }
+        }
+    }
+
+    base := raytracer.Vector{X: baseColor[0], Y: baseColor[1], Z: baseColor[2]}
+    // Dielectrics reflect about 4% head on
+    specular := raytracer.Vector{X: 0.04, Y: 0.04, Z: 0.04}.VectorScale(1 - metallic).VectorAdd(base.VectorScale(metallic))
+    alpha := math.Max(roughness*roughness, 1e-2)
+    material := &Material{
+        diffuse: base.VectorScale(1 - metallic),
+        specular: specular,
+        shininess: math.Max(1, 2/(alpha*alpha) - 2),
+        reflective: specular.VectorScale((1 - roughness)*(1 - roughness)),
+        ior: 1,
+        diffuseTexture: texture,
+    }
+
+    if extension := source.Extensions.Transmission; extension != nil && extension.TransmissionFactor > 0 {
+        material.ior = 1.5
+        if ior := source.Extensions.IOR; ior != nil && ior.IOR != nil && *ior.IOR > 0 {
+            material.ior = *ior.IOR
+        }
+        material.transmission = base.VectorScale(extension.TransmissionFactor*(1 - metallic))
+        material.diffuse = material.diffuse.VectorScale(1 - extension.TransmissionFactor)
+    } else if source.AlphaMode == "BLEND" && baseColor[3] < 1 {
+        // Blended surfaces let light through without bending it
+        material.transmission = raytracer.Vector{X: 1, Y: 1, Z: 1}.VectorScale(1 - baseColor[3])
+        material.diffuse = material.diffuse.VectorScale(baseColor[3])
+    }
+    importer.materials[index] = material
+    return material, nil
+}
+
+// Decodes the PNG or JPEG image of a texture, from a file, data URI or
+// buffer view
+func (importer *gltfImporter) texture(index int) (*Texture, error) {
+    document := &importer.document
+    if index < 0 || index >= len(document.Textures) || document.Textures[index].Source == nil {
+        return nil, fmt.Errorf("%s: texture %d does not exist or has no image", importer.filename, index)
+    }
+    source := *document.Textures[index].Source
+    if texture, ok := importer.textures[source]; ok {
+        return texture, nil
+    }
+    if source < 0 || source >= len(document.Images) {
+        return nil, fmt.Errorf("%s: image %d does not exist", importer.filename, source)
+    }
+    image := document.Images[source]
+    var data []byte
+    if image.BufferView != nil {
+        viewIndex := *image.BufferView
+        if viewIndex < 0 || viewIndex >= len(document.BufferViews) {
+            return nil, fmt.Errorf("%s: image %d: buffer view %d does not exist", importer.filename, source, viewIndex)
+        }
+        view := document.BufferViews[viewIndex]
+        if view.Buffer < 0 || view.Buffer >= len(importer.buffers) || view.ByteOffset < 0 || view.ByteLength < 0 ||
+            view.ByteOffset + view.ByteLength > len(importer.buffers[view.Buffer]) {
+            return nil, fmt.Errorf("%s: image %d: buffer view %d is outside its buffer", importer.filename, source, viewIndex)
+        }
+        data = importer.buffers[view.Buffer][view.ByteOffset:view.ByteOffset + view.ByteLength]
+    } else {
+        var err error
+        if data, err = importer.readURI(image.URI); err != nil {
+            return nil, fmt.Errorf("%s: image %d: %v", importer.filename, source, err)
+        }
+    }
+    texture, err := decodeTexture(bytes.NewReader(data))
+    if err != nil {
+        return nil, fmt.Errorf("%s: image %d: %v", importer.filename, source, err)
+    }
+    importer.textures[source] = texture
+    return texture, nil
+}
+
+// Places the image plane a unit in front of a perspective camera, which
+// looks down its -Z axis with +Y up. Without an aspect ratio the plane is
+// square.
+func (scene *Scene) setGltfCamera(importer *gltfImporter, index int, world raytracer.Matrix4) error {
+    document := &importer.document
+    if index < 0 || index >= len(document.Cameras) {
+        return fmt.Errorf("%s: camera %d does not exist", importer.filename, index)
+    }
+    camera := document.Cameras[index]
+    if camera.Type != "perspective" {
+        return fmt.Errorf("%s: camera %d is %s, only perspective cameras are supported", importer.filename, index, camera.Type)
+    }
+    if camera.Perspective.Yfov <= 0 || camera.Perspective.Yfov >= math.Pi {
+        return fmt.Errorf("%s: camera %d has a yfov of %g radians", importer.filename, index, camera.Perspective.Yfov)
+    }
+    aspect := camera.Perspective.AspectRatio
+    if aspect <= 0 {
+        aspect = 1
+    }
+    halfHeight := math.Tan(camera.Perspective.Yfov/2)
+    halfWidth := halfHeight*aspect
+    corner := func(x float64, y float64) raytracer.Vector {
+        return world.TransformPoint(raytracer.Vector{X: x*halfWidth, Y: y*halfHeight, Z: -1}).VectorScale(SCALE_FACTOR)
+    }
+    scene.eye = world.TransformPoint(emptyVector()).VectorScale(SCALE_FACTOR)
+    scene.lowerLeft, scene.lowerRight = corner(-1, -1), corner(1, -1)
+    scene.upperLeft, scene.upperRight = corner(-1, 1), corner(1, 1)
+    return nil
+}
+
+// Point and spot lights become point lights with quadratic falloff, spot
+// cones are ignored. Directional lights shine down their node's -Z axis.
+func (scene *Scene) addGltfLight(importer *gltfImporter, index int, world raytracer.Matrix4) error {
+    lights := importer.document.Extensions.LightsPunctual.Lights
+    if index < 0 || index >= len(lights) {
+        return fmt.Errorf("%s: light %d does not exist", importer.filename, index)
+    }
+    light := lights[index]
+    color := raytracer.Vector{X: 1, Y: 1, Z: 1}
+    if light.Color != nil {
+        if len(light.Color) != 3 {
+            return fmt.Errorf("%s: light %d: color has %d values, expected 3", importer.filename, index, len(light.Color))
+        }
+        color = raytracer.Vector{X: light.Color[0], Y: light.Color[1], Z: light.Color[2]}
+    }
+    if light.Intensity != nil {
+        color = color.VectorScale(*light.Intensity)
+    }
+    switch light.Type {
+    case "point", "spot":
+        position := world.TransformPoint(emptyVector()).VectorScale(SCALE_FACTOR)
+        scene.pointLights = append(scene.pointLights, PointLight{position: position, color: color, falloff: 2})
+    case "directional":
+        // Like ltd, the direction is the one the light travels
+        direction := world.TransformDirection(raytracer.Vector{X: 0, Y: 0, Z: -1}).Normalize()
+        scene.directionalLights = append(scene.directionalLights, DirectionalLight{direction: direction, color: color})
+    default:
+        return fmt.Errorf("%s: light %d has unknown type %q", importer.filename, index, light.Type)
+    }
+    return nil
+}
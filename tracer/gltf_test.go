@@ -0,0 +1,104 @@
+package tracer
+
+import (
+    "bytes"
+    "encoding/base64"
+    "encoding/binary"
+    "fmt"
+    "math"
+    "path/filepath"
+    "strings"
+    "testing"
+    "../vector"
+)
+
+func TestGltfImport(t *testing.T) {
+    var buffer bytes.Buffer
+    binary.Write(&buffer, binary.LittleEndian, [3][3]float32{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}})
+    document := func(bufferURI string, extra string) string {
+        return `{"asset": {"version": "2.0"}, "scene": 0, "scenes": [{"nodes": [0, 2, 3]}],` +
+            `"nodes": [{"translation": [0, 0, -5], "children": [1]}, {"mesh": 0, "scale": [2, 2, 2]}, {"camera": 0},` +
+            `{"translation": [0, 3, 0], "extensions": {"KHR_lights_punctual": {"light": 0}}}],` +
+            `"meshes": [{"name": "triangle", "primitives": [{"attributes": {"POSITION": 0}, "material": 0}]}],` +
+            `"materials": [{"pbrMetallicRoughness": {"baseColorFactor": [0.5, 0.5, 0.5, 1], "metallicFactor": 0}}],` +
+            `"cameras": [{"type": "perspective", "perspective": {"yfov": ` + fmt.Sprint(2*math.Atan(0.5)) + `, "aspectRatio": 2}}],` +
+            `"extensions": {"KHR_lights_punctual": {"lights": [{"type": "point", "color": [1, 0.5, 0.5], "intensity": 5}]}},` +
+            `"accessors": [{"bufferView": 0, "componentType": 5126, "count": 3, "type": "VEC3"}],` +
+            `"bufferViews": [{"buffer": 0, "byteLength": 36}],` +
+            `"buffers": [{"byteLength": 36` + bufferURI + `}]` + extra + `}`
+    }
+    // The same document with its buffer in a GLB container
+    text := []byte(document("", ""))
+    for len(text)%4 != 0 {
+        text = append(text, ' ')
+    }
+    var glb bytes.Buffer
+    binary.Write(&glb, binary.LittleEndian, [3]uint32{0x46546C67, 2, uint32(12 + 8 + len(text) + 8 + buffer.Len())})
+    binary.Write(&glb, binary.LittleEndian, uint32(len(text)))
+    glb.WriteString("JSON")
+    glb.Write(text)
+    binary.Write(&glb, binary.LittleEndian, uint32(buffer.Len()))
+    glb.WriteString("BIN\x00")
+    glb.Write(buffer.Bytes())
+    // A mesh placed by two nodes
+    twice := strings.Replace(document(`, "uri": "model.bin"`, ""), `{"light": 0}}}]`, `{"light": 0}}}, {"mesh": 0, "translation": [5, 0, 0]}]`, 1)
+    directory := writeFixtures(t, map[string]string{
+        "scene.gltf": document(`, "uri": "data:application/octet-stream;base64,` + base64.StdEncoding.EncodeToString(buffer.Bytes()) + `"`, ""),
+        "model.glb": glb.String(),
+        "scene.txt": "xft 1 0 0\nmesh model.glb\n",
+        "twice.gltf": strings.Replace(twice, `"children": [1]`, `"children": [1, 4]`, 1),
+        "model.bin": buffer.String(),
+        "twice.txt": "mesh twice.gltf\n",
+        "draco.gltf": document("", `, "extensionsRequired": ["KHR_draco_mesh_compression"]`),
+    })
+    path := func(name string) string {
+        return filepath.Join(directory, name)
+    }
+    hitZ := func(scene *Scene, x float64) (float64, *Material) {
+        ray := Ray{start: raytracer.Vector{X: x, Y: 5, Z: 100}, direction: raytracer.Vector{X: 0, Y: 0, Z: -1}}
+        hit, ok := scene.bvh.closestHit(ray, 0, math.MaxFloat64, NO_SHAPE)
+        if !ok {
+            return math.NaN(), nil
+        }
+        return hit.position.Z, hit.material
+    }
+
+    scene, err := ParseScene(path("scene.gltf"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if z, material := hitZ(scene, 15); len(scene.shapes) != 1 || math.Abs(z + 50) > 1e-9 || material == nil || material.diffuse.X != 0.5 {
+        t.Errorf("Expected the scaled triangle 5 units away with its material, got %d shapes, a hit at z = %g", len(scene.shapes), z)
+    }
+    if scene.eye != emptyVector() || !scene.lowerLeft.Equals(raytracer.Vector{X: -10, Y: -5, Z: -10}) || !scene.upperRight.Equals(raytracer.Vector{X: 10, Y: 5, Z: -10}) {
+        t.Errorf("Expected the camera's image plane a unit ahead, got eye %v, corners %v and %v", scene.eye, scene.lowerLeft, scene.upperRight)
+    }
+    if len(scene.pointLights) != 1 || scene.pointLights[0] != (PointLight{position: raytracer.Vector{X: 0, Y: 30, Z: 0}, color: raytracer.Vector{X: 5, Y: 2.5, Z: 2.5}, falloff: 2}) {
+        t.Errorf("Expected the point light scaled by its intensity, got %+v", scene.pointLights)
+    }
+
+    // As a mesh in a text scene, only the geometry is added
+    scene, err = ParseScene(path("scene.txt"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if z, _ := hitZ(scene, 25); len(scene.shapes) != 1 || math.Abs(z + 50) > 1e-9 || len(scene.pointLights) != 0 {
+        t.Errorf("Expected the moved triangle without the file's light, got %d shapes, a hit at z = %g", len(scene.shapes), z)
+    }
+
+    // A mesh placed by two nodes is one mesh in two instances
+    single := scene.meshes[0].memoryUsage()
+    if scene, err = ParseScene(path("twice.txt")); err != nil {
+        t.Fatal(err)
+    }
+    if len(scene.shapes) != 2 || len(scene.meshes) != 1 || scene.meshes[0].instances != 2 {
+        t.Errorf("Expected one mesh in two instances, got %d shapes and meshes %+v", len(scene.shapes), scene.meshes)
+    }
+    if usage := scene.meshes[0].memoryUsage(); usage <= single || usage >= 2*single {
+        t.Errorf("Expected the shared buffers counted once, got %d bytes for two instances and %d for one", usage, single)
+    }
+
+    if _, err := ParseScene(path("draco.gltf")); err == nil || !strings.Contains(err.Error(), "KHR_draco_mesh_compression") {
+        t.Errorf("Expected an error naming the unsupported extension, got %v", err)
+    }
+}
@@ -0,0 +1,113 @@
+package tracer
+
+import (
+    "math"
+    "../vector"
+)
+
+// Integrator turns hits into colors: Phong lighting from the scene's lights
+// plus mirror reflections and refraction through transparent shapes.
+type Integrator struct {
+    scene *Scene
+    // Most bounces after the camera ray
+    maxDepth int
+    // Bounces that would add less than this to any channel of their pixel
+    // are not traced
+    minContribution float64
+}
+
+// The rays spawned by one camera ray
+type rayTree struct {
+    // Most bounces taken by any of them
+    depth int
+    culled int64
+}
+
+func newIntegrator(scene *Scene, maxDepth int, minContribution float64) *Integrator {
+    return &Integrator{scene: scene, maxDepth: maxDepth, minContribution: minContribution}
+}
+
+// Color seen along the ray and whether it hit anything. The shape with ID
+// exclude is skipped so reflected rays do not hit the surface they leave.
+// The ray is the given bounce after the camera ray, and throughput is the
+// share of its color that reaches the pixel.
+func (integrator *Integrator) radiance(ray Ray, exclude int, bounce int, throughput raytracer.Vector, tree *rayTree) (raytracer.Vector, bool) {
+    hit, isHit := integrator.scene.bvh.closestHit(ray, 0, math.MaxFloat64, exclude)
+    if !isHit {
+        return emptyVector(), false
+    }
+    return integrator.shade(ray, hit, bounce, throughput, tree), true
+}
+
+func (integrator *Integrator) shade(ray Ray, hit Hit, bounce int, throughput raytracer.Vector, tree *rayTree) raytracer.Vector {
+    material := *hit.material
+    // Vertex colors are the diffuse color, so they show without a mat
+    if hit.hasVertexColor {
+        material.diffuse = hit.vertexColor
+    }
+    if material.diffuseTexture != nil {
+        material.diffuse = material.diffuse.VectorMult(material.diffuseTexture.sample(hit.u, hit.v))
+    }
+    color := integrator.scene.calculateColor(material, hit.position, hit.shadingNormal, ray)
+    if material.isTransparent() {
+        return color.VectorAdd(integrator.dielectric(ray, hit, bounce, throughput, tree))
+    }
+    if material.reflective != emptyVector() {
+        reflectedRay := Ray{start: hit.position, direction: reflectionLight(ray.direction, hit.shadingNormal)}
+        color = color.VectorAdd(integrator.bounce(reflectedRay, hit.shape.ID(), bounce+1, throughput, material.reflective, tree))
+    }
+    return color
+}
+
+// Color along a secondary ray scaled by weight. Nothing once the rays are
+// maxDepth bounces deep, or when the ray's share of the pixel would fall
+// below minContribution.
+func (integrator *Integrator) bounce(ray Ray, exclude int, bounce int, throughput raytracer.Vector, weight raytracer.Vector, tree *rayTree) raytracer.Vector {
+    if bounce > integrator.maxDepth {
+        return emptyVector()
+    }
+    throughput = throughput.VectorMult(weight)
+    if math.Max(throughput.X, math.Max(throughput.Y, throughput.Z)) < integrator.minContribution {
+        tree.culled++
+        return emptyVector()
+    }
+    tree.depth = max(tree.depth, bounce)
+    color, isHit := integrator.radiance(ray, exclude, bounce, throughput, tree)
+    if !isHit {
+        return emptyVector()
+    }
+    return color.VectorMult(weight)
+}
+
+// Light reflected and refracted at a transparent surface. The Fresnel term
+// moves the transmitted light to the reflected ray at grazing angles, all of
+// it on total internal reflection. Both rays may hit the shape they leave
+// again, so they start off the surface rather than excluding it.
+func (integrator *Integrator) dielectric(ray Ray, hit Hit, bounce int, throughput raytracer.Vector, tree *rayTree) raytracer.Vector {
+    material := hit.material
+    direction := ray.direction.Normalize()
+    normal := hit.shadingNormal
+    eta := 1/material.ior
+    cosIncident := -direction.DotProduct(normal)
+    // Leaving the shape
+    if cosIncident < 0 {
+        normal, eta, cosIncident = normal.VectorScale(-1), material.ior, -cosIncident
+    }
+
+    color := emptyVector()
+    reflectance := 1.0
+    refracted, cosTransmitted, ok := refractionLight(direction, normal, eta)
+    if ok {
+        if eta < 1 {
+            reflectance = schlickReflectance(cosIncident, material.ior)
+        } else {
+            reflectance = schlickReflectance(cosTransmitted, material.ior)
+        }
+        refractedRay := offsetRay(hit.position, hit.geometricNormal, refracted)
+        color = color.VectorAdd(integrator.bounce(refractedRay, NO_SHAPE, bounce+1, throughput, material.transmission.VectorScale(1 - reflectance), tree))
+    }
+
+    reflectedRay := offsetRay(hit.position, hit.geometricNormal, reflectionLight(direction, normal))
+    weight := material.reflective.VectorAdd(material.transmission.VectorScale(reflectance))
+    return color.VectorAdd(integrator.bounce(reflectedRay, NO_SHAPE, bounce+1, throughput, weight, tree))
+}
@@ -0,0 +1,166 @@
+package tracer
+
+import (
+    "fmt"
+    "unsafe"
+    "../vector"
+)
+
+// Mesh holds vertices once for all the triangles sharing them, along with
+// the triangles' transformation and material
+type Mesh struct {
+    // File the mesh was loaded from, empty for tri commands
+    name string
+    positions []raytracer.Vector
+    // Unit vertex normals for smooth shading
+    normals []raytracer.Vector
+    texcoords [][2]float64
+    // Colors of the positions, empty when the file has none
+    colors []raytracer.Vector
+    triangles [][3]MeshVertex
+    // Materials the file assigns to triangles, by index into materials, or
+    // -1 for the mesh's material. Empty when every triangle uses the
+    // mesh's material.
+    materials []*Material
+    triangleMaterials []int32
+    // Degenerate faces left out while loading
+    skippedFaces int
+    // Problems that did not stop loading, such as missing material files
+    warnings []string
+    transform Transform
+    material *Material
+    // The mesh whose buffers this copy shares under its own transform, nil
+    // for a mesh that owns them
+    instanceOf *Mesh
+    // How many meshes in the scene use this one's buffers
+    instances int
+}
+
+// Indices of a triangle corner's position, normal and texture coordinates
+// in its Mesh, -1 for a missing normal or texture coordinates
+type MeshVertex struct {
+    position int32
+    normal int32
+    texcoord int32
+}
+
+func flatVertex(position int) MeshVertex {
+    return MeshVertex{position: int32(position), normal: -1, texcoord: -1}
+}
+
+func newTriangleMesh(a raytracer.Vector, b raytracer.Vector, c raytracer.Vector, transform Transform, material *Material) *Mesh {
+    return &Mesh{
+        positions: []raytracer.Vector{a, b, c},
+        triangles: [][3]MeshVertex{{flatVertex(0), flatVertex(1), flatVertex(2)}},
+        transform: transform,
+        material: material,
+    }
+}
+
+// Object space corners of the triangle at index
+func (mesh *Mesh) corners(index int) (raytracer.Vector, raytracer.Vector, raytracer.Vector) {
+    vertices := mesh.triangles[index]
+    return mesh.positions[vertices[0].position], mesh.positions[vertices[1].position], mesh.positions[vertices[2].position]
+}
+
+func (mesh *Mesh) materialAt(index int) *Material {
+    if len(mesh.triangleMaterials) == 0 || mesh.triangleMaterials[index] < 0 {
+        return mesh.material
+    }
+    return mesh.materials[mesh.triangleMaterials[index]]
+}
+
+// Reads a mesh file in the format named by an extension such as ".ply" and
+// adds its triangles to the scene with the transformation and material. OBJ
+// material libraries and textures are also looked for in the asset
+// directories.
+func (scene *Scene) loadMesh(filename string, format string, transform Transform, material *Material, assetPaths ...string) error {
+    var mesh *Mesh
+    var err error
+    switch format {
+    case ".obj":
+        mesh, err = parseObj(filename, assetPaths...)
+    case ".ply":
+        mesh, err = parsePly(filename)
+    case ".stl":
+        mesh, err = parseStl(filename)
+    case ".gltf", ".glb":
+        // Holds a hierarchy of meshes, each added on its own
+        return scene.importGltf(filename, transform, material, false)
+    default:
+        return fmt.Errorf("%s: unsupported mesh format %q, expected .obj, .ply, .stl, .gltf or .glb", filename, format)
+    }
+    if err != nil {
+        return err
+    }
+    mesh.transform, mesh.material = transform, material
+    scene.addMesh(mesh)
+    return nil
+}
+
+// Adds a Triangle shape for each of the mesh's triangles
+// Instances are listed under the mesh they share buffers with, so it is
+// reported once
+func (scene *Scene) addMesh(mesh *Mesh) {
+    for i := range mesh.triangles {
+        scene.shapes = append(scene.shapes, Triangle{id: len(scene.shapes), mesh: mesh, index: i})
+    }
+    source := mesh
+    if mesh.instanceOf != nil {
+        source = mesh.instanceOf
+    }
+    source.instances++
+    if source.instances == 1 && source.name != "" {
+        scene.meshes = append(scene.meshes, source)
+    }
+}
+
+// Bytes held by the mesh's buffers once, and by the shapes of the
+// triangles of each instance
+func (mesh *Mesh) memoryUsage() int64 {
+    size := int64(cap(mesh.positions))*int64(unsafe.Sizeof(raytracer.Vector{}))
+    size += int64(cap(mesh.normals))*int64(unsafe.Sizeof(raytracer.Vector{}))
+    size += int64(cap(mesh.texcoords))*int64(unsafe.Sizeof([2]float64{}))
+    size += int64(cap(mesh.colors))*int64(unsafe.Sizeof(raytracer.Vector{}))
+    size += int64(cap(mesh.triangles))*int64(unsafe.Sizeof([3]MeshVertex{}))
+    size += int64(cap(mesh.triangleMaterials))*int64(unsafe.Sizeof(int32(0)))
+    instances := int64(mesh.instances)
+    if instances == 0 {
+        instances = 1
+    }
+    // Each triangle is boxed in a Shape interface
+    size += instances*int64(len(mesh.triangles))*int64(unsafe.Sizeof(Triangle{}) + unsafe.Sizeof(Shape(nil)))
+    return size + instances*int64(unsafe.Sizeof(*mesh))
+}
+
+// MeshReport describes a mesh a scene loaded from a file
+type MeshReport struct {
+    Name string
+    Vertices int
+    Triangles int
+    // Nodes of a glTF file placing the mesh, 1 for other files
+    Instances int
+    // Memory held by the mesh's buffers and its triangles' shapes
+    Bytes int64
+    // Degenerate faces left out while loading
+    SkippedFaces int
+    // Problems that did not stop loading, such as missing material files
+    Warnings []string
+}
+
+// Reports on the meshes loaded from files, in the order they were loaded
+func (scene *Scene) MeshReports() []MeshReport {
+    reports := []MeshReport{}
+    for _, mesh := range scene.meshes {
+        reports = append(reports, MeshReport{
+            Name: mesh.name,
+            Vertices: len(mesh.positions),
+            Triangles: len(mesh.triangles),
+            Instances: max(1, mesh.instances),
+            Bytes: mesh.memoryUsage(),
+            SkippedFaces: mesh.skippedFaces,
+            Warnings: mesh.warnings,
+        })
+    }
+    return reports
+}
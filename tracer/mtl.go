@@ -0,0 +1,98 @@
+package tracer
+
+import (
+    "fmt"
+    "math"
+    "../vector"
+)
+
+// Reads the materials of an MTL file by name. Ka, Kd, Ks and Ns map onto
+// the Phong terms, d or Tr below full opacity makes the material
+// transparent with Ni as its index of refraction, and map_Kd textures the
+// diffuse color. Textures are found relative to the MTL file and then in
+// the asset directories. A texture that cannot be loaded is skipped with a
+// warning, leaving the rest of its material.
+func interpretMtl(filename string, lines []string, assetPaths ...string) (map[string]*Material, []string, error) {
+    materials := map[string]*Material{}
+    warnings := []string{}
+    var material *Material
+    name := ""
+    for lineIndex, line := range lines {
+        tokens := tokenize(line)
+        if len(tokens) == 0 {
+            continue
+        }
+        mtlError := func(column int, format string, a ...interface{}) error {
+            return &SceneError{filename: filename, line: lineIndex + 1, column: column, message: fmt.Sprintf(format, a...)}
+        }
+
+        statement, arguments := tokens[0], tokens[1:]
+        if statement.text == "newmtl" {
+            if len(arguments) != 1 {
+                return nil, nil, mtlError(statement.column, "newmtl expects a name")
+            }
+            material, name = &Material{ior: 1}, arguments[0].text
+            materials[name] = material
+            continue
+        }
+        switch statement.text {
+        case "Ka", "Kd", "Ks", "Ns", "Ni", "d", "Tr", "map_Kd":
+        default:
+            continue
+        }
+        if material == nil {
+            return nil, nil, mtlError(statement.column, "%s before any newmtl", statement.text)
+        }
+        if len(arguments) == 0 {
+            return nil, nil, mtlError(statement.column, "%s expects an argument", statement.text)
+        }
+        if statement.text == "map_Kd" {
+            // Options such as -s come before the file name
+            textureName := arguments[len(arguments)-1]
+            path, err := findAsset(filename, textureName.text, assetPaths)
+            var texture *Texture
+            if err == nil {
+                texture, err = loadTexture(path)
+            }
+            if err != nil {
+                warnings = append(warnings, mtlError(textureName.column, "%v, %s is left untextured", err, name).Error())
+                continue
+            }
+            material.diffuseTexture = texture
+            continue
+        }
+
+        numbers, badToken := parseNumbers(arguments[:min(len(arguments), 3)])
+        if badToken != nil {
+            return nil, nil, mtlError(badToken.column, "expected number, got %q", badToken.text)
+        }
+        // A single number is a gray
+        color := raytracer.Vector{X: numbers[0], Y: numbers[0], Z: numbers[0]}
+        if len(numbers) == 3 {
+            color = vectorAt(numbers, 0)
+        }
+        switch statement.text {
+        case "Ka":
+            material.ambient = color
+        case "Kd":
+            material.diffuse = color
+        case "Ks":
+            material.specular = color
+        case "Ns":
+            material.shininess = numbers[0]
+        case "Ni":
+            if numbers[0] <= 0 {
+                return nil, nil, mtlError(arguments[0].column, "Ni must be positive, got %q", arguments[0].text)
+            }
+            material.ior = numbers[0]
+        case "d", "Tr":
+            opacity := numbers[0]
+            if statement.text == "Tr" {
+                opacity = 1 - opacity
+            }
+            transparency := 1 - math.Max(0, math.Min(1, opacity))
+            material.transmission = raytracer.Vector{X: transparency, Y: transparency, Z: transparency}
+        }
+    }
+    return materials, warnings, nil
+}
@@ -0,0 +1,262 @@
+package tracer
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "../vector"
+)
+
+// Reads v, vt, vn and f statements. Faces may be n-gons, with vertices
+// written v, v/vt, v//vn or v/vt/vn and negative indices counting back from
+// the latest element. Faces are smoothed unless an s off statement precedes
+// them. usemtl picks a material from the files named by mtllib, found
+// relative to the OBJ file and then in the asset directories; faces before
+// it or with unknown names keep the mesh's material, and so do those of
+// libraries that cannot be read, with a warning. Statements for other
+// features are skipped.
+func interpretObj(filename string, lines []string, assetPaths ...string) (*Mesh, error) {
+    mesh := &Mesh{name: filename}
+    // Whether each triangle is in a smoothing group
+    smooth := []bool{}
+    isSmooth := true
+    library := map[string]*Material{}
+    // Indices in mesh.materials of the materials used so far
+    used := map[string]int32{}
+    currentMaterial := int32(-1)
+    for lineIndex, line := range lines {
+        tokens := tokenize(line)
+        if len(tokens) == 0 {
+            continue
+        }
+        objError := func(column int, format string, a ...interface{}) error {
+            return &SceneError{filename: filename, line: lineIndex + 1, column: column, message: fmt.Sprintf(format, a...)}
+        }
+
+        statement, arguments := tokens[0], tokens[1:]
+        switch statement.text {
+        case "v", "vn", "vt":
+            minimum := map[string]int{"v": 3, "vn": 3, "vt": 1}[statement.text]
+            if len(arguments) < minimum {
+                return nil, objError(statement.column, "%s expects at least %d numbers, got %d", statement.text, minimum, len(arguments))
+            }
+            // Extra numbers such as w or vertex colors are ignored
+            numbers, badToken := parseNumbers(arguments[:min(len(arguments), 3)])
+            if badToken != nil {
+                return nil, objError(badToken.column, "expected number, got %q", badToken.text)
+            }
+            switch statement.text {
+            case "v":
+                mesh.positions = append(mesh.positions, vectorAt(numbers, 0).VectorScale(SCALE_FACTOR))
+            case "vn":
+                mesh.normals = append(mesh.normals, vectorAt(numbers, 0).Normalize())
+            case "vt":
+                texcoord := [2]float64{numbers[0], 0}
+                if len(numbers) > 1 {
+                    texcoord[1] = numbers[1]
+                }
+                mesh.texcoords = append(mesh.texcoords, texcoord)
+            }
+        case "f":
+            if len(arguments) < 3 {
+                return nil, objError(statement.column, "f expects at least 3 vertices, got %d", len(arguments))
+            }
+            face := make([]MeshVertex, len(arguments))
+            for i, argument := range arguments {
+                vertex, err := mesh.parseVertex(argument.text)
+                if err != nil {
+                    return nil, objError(argument.column, "%v", err)
+                }
+                face[i] = vertex
+            }
+            for _, triangle := range mesh.triangulate(face) {
+                mesh.triangles = append(mesh.triangles, triangle)
+                smooth = append(smooth, isSmooth)
+                mesh.triangleMaterials = append(mesh.triangleMaterials, currentMaterial)
+            }
+        case "mtllib":
+            for _, argument := range arguments {
+                mtlFilename, err := findAsset(filename, argument.text, assetPaths)
+                var mtlLines []string
+                if err == nil {
+                    mtlLines, err = readLines(mtlFilename)
+                }
+                if err != nil {
+                    mesh.warnings = append(mesh.warnings, objError(argument.column, "%v, its materials use the scene's material", err).Error())
+                    continue
+                }
+                materials, warnings, err := interpretMtl(mtlFilename, mtlLines, assetPaths...)
+                if err != nil {
+                    return nil, err
+                }
+                mesh.warnings = append(mesh.warnings, warnings...)
+                for name, material := range materials {
+                    library[name] = material
+                }
+            }
+        case "usemtl":
+            currentMaterial = -1
+            if len(arguments) == 0 {
+                continue
+            }
+            name := arguments[0].text
+            if index, ok := used[name]; ok {
+                currentMaterial = index
+            } else if material, ok := library[name]; ok {
+                currentMaterial = int32(len(mesh.materials))
+                used[name] = currentMaterial
+                mesh.materials = append(mesh.materials, material)
+            }
+        case "s":
+            isSmooth = len(arguments) == 0 || (arguments[0].text != "off" && arguments[0].text != "0")
+        }
+    }
+    if len(mesh.materials) == 0 {
+        mesh.triangleMaterials = nil
+    }
+    mesh.addSmoothNormals(smooth)
+    return mesh, nil
+}
+
+// Parses v, v/vt, v//vn or v/vt/vn
+func (mesh *Mesh) parseVertex(text string) (MeshVertex, error) {
+    fields := strings.Split(text, "/")
+    if len(fields) > 3 {
+        return MeshVertex{}, fmt.Errorf("malformed face vertex %q", text)
+    }
+    indices := [3]int32{-1, -1, -1}
+    counts := [3]int{len(mesh.positions), len(mesh.texcoords), len(mesh.normals)}
+    names := [3]string{"vertex", "texture coordinate", "normal"}
+    for i, field := range fields {
+        if field == "" && i > 0 {
+            continue
+        }
+        index, err := strconv.Atoi(field)
+        if err != nil {
+            return MeshVertex{}, fmt.Errorf("malformed face vertex %q", text)
+        }
+        if index < 0 {
+            index += counts[i] + 1
+        }
+        if index < 1 || index > counts[i] {
+            return MeshVertex{}, fmt.Errorf("%s index %s out of range in %q", names[i], field, text)
+        }
+        indices[i] = int32(index - 1)
+    }
+    return MeshVertex{position: indices[0], texcoord: indices[1], normal: indices[2]}, nil
+}
+
+// Splits a polygon into triangles by clipping ears, so concave faces work
+// too. Falls back to a fan for polygons too degenerate to clip.
+func (mesh *Mesh) triangulate(face []MeshVertex) [][3]MeshVertex {
+    if len(face) == 3 {
+        return [][3]MeshVertex{{face[0], face[1], face[2]}}
+    }
+    // Newell's method gives the polygon's normal even when it is not planar
+    normal := emptyVector()
+    for i := range face {
+        current, next := mesh.positions[face[i].position], mesh.positions[face[(i+1)%len(face)].position]
+        normal = normal.VectorAdd(raytracer.Vector{
+            X: (current.Y - next.Y)*(current.Z + next.Z),
+            Y: (current.Z - next.Z)*(current.X + next.X),
+            Z: (current.X - next.X)*(current.Y + next.Y),
+        })
+    }
+
+    triangles := [][3]MeshVertex{}
+    remaining := append([]MeshVertex{}, face...)
+    for len(remaining) > 3 {
+        ear := -1
+        for i := range remaining {
+            if mesh.isEar(remaining, i, normal) {
+                ear = i
+                break
+            }
+        }
+        if ear == -1 {
+            break
+        }
+        previous, next := (ear + len(remaining) - 1)%len(remaining), (ear + 1)%len(remaining)
+        triangles = append(triangles, [3]MeshVertex{remaining[previous], remaining[ear], remaining[next]})
+        remaining = append(remaining[:ear], remaining[ear+1:]...)
+    }
+    for i := 1; i + 1 < len(remaining); i++ {
+        triangles = append(triangles, [3]MeshVertex{remaining[0], remaining[i], remaining[i+1]})
+    }
+    return triangles
+}
+
+// Whether the corner at i is convex and no other vertex lies inside it
+func (mesh *Mesh) isEar(polygon []MeshVertex, i int, normal raytracer.Vector) bool {
+    a := mesh.positions[polygon[(i + len(polygon) - 1)%len(polygon)].position]
+    b := mesh.positions[polygon[i].position]
+    c := mesh.positions[polygon[(i + 1)%len(polygon)].position]
+    if b.VectorSub(a).CrossProduct(c.VectorSub(b)).DotProduct(normal) <= 0 {
+        return false
+    }
+    for j := range polygon {
+        if j == i || j == (i + len(polygon) - 1)%len(polygon) || j == (i + 1)%len(polygon) {
+            continue
+        }
+        p := mesh.positions[polygon[j].position]
+        if b.VectorSub(a).CrossProduct(p.VectorSub(a)).DotProduct(normal) >= 0 &&
+            c.VectorSub(b).CrossProduct(p.VectorSub(b)).DotProduct(normal) >= 0 &&
+            a.VectorSub(c).CrossProduct(p.VectorSub(c)).DotProduct(normal) >= 0 {
+            return false
+        }
+    }
+    return true
+}
+
+// Gives the smooth faces without normals of their own the area weighted
+// average of the normals of those faces around each vertex. Vertices
+// repeated at the same place, as along the seams between the teapot's
+// patches, share one.
+func (mesh *Mesh) addSmoothNormals(smooth []bool) {
+    needsNormals := func(i int) bool {
+        vertices := mesh.triangles[i]
+        return smooth[i] && (vertices[0].normal < 0 || vertices[1].normal < 0 || vertices[2].normal < 0)
+    }
+    sums := map[raytracer.Vector]raytracer.Vector{}
+    for i := range mesh.triangles {
+        if !needsNormals(i) {
+            continue
+        }
+        a, b, c := mesh.corners(i)
+        // The cross product's length is twice the triangle's area
+        faceNormal := b.VectorSub(a).CrossProduct(c.VectorSub(a))
+        for _, position := range []raytracer.Vector{a, b, c} {
+            sums[position] = sums[position].VectorAdd(faceNormal)
+        }
+    }
+
+    indices := map[raytracer.Vector]int32{}
+    for i := range mesh.triangles {
+        if !needsNormals(i) {
+            continue
+        }
+        a, b, c := mesh.corners(i)
+        corners := [3]raytracer.Vector{a, b, c}
+        // Degenerate surroundings leave the triangle flat
+        if sums[a] == emptyVector() || sums[b] == emptyVector() || sums[c] == emptyVector() {
+            continue
+        }
+        for j, position := range corners {
+            index, ok := indices[position]
+            if !ok {
+                index = int32(len(mesh.normals))
+                indices[position] = index
+                mesh.normals = append(mesh.normals, sums[position].Normalize())
+            }
+            mesh.triangles[i][j].normal = index
+        }
+    }
+}
+
+func parseObj(filename string, assetPaths ...string) (*Mesh, error) {
+    lines, err := readLines(filename)
+    if err != nil {
+        return nil, err
+    }
+    return interpretObj(filename, lines, assetPaths...)
+}
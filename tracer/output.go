@@ -0,0 +1,172 @@
+package tracer
+
+import (
+    "bufio"
+    "encoding/binary"
+    "fmt"
+    "image"
+    "image/color"
+    "image/jpeg"
+    "image/png"
+    "math"
+    "os"
+    "path/filepath"
+    "strings"
+    "../vector"
+)
+
+// Framebuffer holds unclipped linear colors so float formats keep the full range
+type Framebuffer struct {
+    width int
+    height int
+    pixels []raytracer.Vector
+}
+
+// OutputOptions controls how SaveImage encodes a Framebuffer
+type OutputOptions struct {
+    // 8 or 16 bits per channel for PNG and PPM
+    BitDepth int
+    JPEGQuality int
+}
+
+func DefaultOutputOptions() OutputOptions {
+    return OutputOptions{BitDepth: 8, JPEGQuality: 90}
+}
+
+func newFramebuffer(width int, height int) *Framebuffer {
+    return &Framebuffer{width: width, height: height, pixels: make([]raytracer.Vector, width*height)}
+}
+
+func (framebuffer *Framebuffer) set(x int, y int, color raytracer.Vector) {
+    framebuffer.pixels[y*framebuffer.width + x] = color
+}
+
+// Size of the image in pixels
+func (framebuffer *Framebuffer) Size() (int, int) {
+    return framebuffer.width, framebuffer.height
+}
+
+// Unclipped linear color of the pixel, with (0, 0) at the top left
+func (framebuffer *Framebuffer) At(x int, y int) raytracer.Vector {
+    return framebuffer.pixels[y*framebuffer.width + x]
+}
+
+func (framebuffer *Framebuffer) copy() *Framebuffer {
+    copied := newFramebuffer(framebuffer.width, framebuffer.height)
+    copy(copied.pixels, framebuffer.pixels)
+    return copied
+}
+
+func (framebuffer *Framebuffer) toRGBA() *image.RGBA {
+    canvas := image.NewRGBA(image.Rect(0, 0, framebuffer.width, framebuffer.height))
+    for y := 0; y < framebuffer.height; y++ {
+        for x := 0; x < framebuffer.width; x++ {
+            color := framebuffer.At(x, y)
+            clip(&color)
+            drawPixel(canvas, float64(x), float64(y), color.X, color.Y, color.Z)
+        }
+    }
+    return canvas
+}
+
+func floatToRGB16(color float64) uint16 {
+    return uint16(math.Floor(color*65535))
+}
+
+func (framebuffer *Framebuffer) toRGBA64() *image.RGBA64 {
+    canvas := image.NewRGBA64(image.Rect(0, 0, framebuffer.width, framebuffer.height))
+    for y := 0; y < framebuffer.height; y++ {
+        for x := 0; x < framebuffer.width; x++ {
+            c := framebuffer.At(x, y)
+            clip(&c)
+            canvas.SetRGBA64(x, y, color.RGBA64{R: floatToRGB16(c.X), G: floatToRGB16(c.Y), B: floatToRGB16(c.Z), A: 65535})
+        }
+    }
+    return canvas
+}
+
+// The file extension picks the encoder: .png, .jpg/.jpeg, .ppm or .pfm
+func SaveImage(framebuffer *Framebuffer, filename string, options OutputOptions) error {
+    if options.BitDepth != 8 && options.BitDepth != 16 {
+        return fmt.Errorf("unsupported bit depth %d, expected 8 or 16", options.BitDepth)
+    }
+    extension := strings.ToLower(filepath.Ext(filename))
+    switch extension {
+    case ".png", ".jpg", ".jpeg", ".ppm", ".pfm":
+    default:
+        return fmt.Errorf("%s: unsupported image format %q", filename, extension)
+    }
+
+    file, err := os.Create(filename)
+    if err != nil {
+        return err
+    }
+    writer := bufio.NewWriter(file)
+    switch extension {
+    case ".png":
+        if options.BitDepth == 16 {
+            err = png.Encode(writer, framebuffer.toRGBA64())
+        } else {
+            err = png.Encode(writer, framebuffer.toRGBA())
+        }
+    case ".jpg", ".jpeg":
+        err = jpeg.Encode(writer, framebuffer.toRGBA(), &jpeg.Options{Quality: options.JPEGQuality})
+    case ".ppm":
+        err = framebuffer.writePPM(writer, options.BitDepth)
+    case ".pfm":
+        err = framebuffer.writePFM(writer)
+    }
+    if err == nil {
+        err = writer.Flush()
+    }
+    if closeErr := file.Close(); err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        return fmt.Errorf("%s: %v", filename, err)
+    }
+    return nil
+}
+
+// Binary P6, with two big endian bytes per channel at 16 bits
+func (framebuffer *Framebuffer) writePPM(writer *bufio.Writer, bitDepth int) error {
+    maxValue := 255
+    if bitDepth == 16 {
+        maxValue = 65535
+    }
+    if _, err := fmt.Fprintf(writer, "P6\n%d %d\n%d\n", framebuffer.width, framebuffer.height, maxValue); err != nil {
+        return err
+    }
+    for _, c := range framebuffer.pixels {
+        clip(&c)
+        for _, channel := range []float64{c.X, c.Y, c.Z} {
+            var err error
+            if bitDepth == 16 {
+                err = binary.Write(writer, binary.BigEndian, floatToRGB16(channel))
+            } else {
+                err = writer.WriteByte(floatToRGB(channel))
+            }
+            if err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// Little endian float RGB, stored bottom row first. Colors are not clipped.
+func (framebuffer *Framebuffer) writePFM(writer *bufio.Writer) error {
+    if _, err := fmt.Fprintf(writer, "PF\n%d %d\n-1.0\n", framebuffer.width, framebuffer.height); err != nil {
+        return err
+    }
+    for y := framebuffer.height - 1; y >= 0; y-- {
+        for x := 0; x < framebuffer.width; x++ {
+            c := framebuffer.At(x, y)
+            row := [3]float32{float32(c.X), float32(c.Y), float32(c.Z)}
+            if err := binary.Write(writer, binary.LittleEndian, row); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
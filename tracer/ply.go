@@ -0,0 +1,302 @@
+package tracer
+
+import (
+    "bufio"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "math"
+    "os"
+    "strconv"
+    "strings"
+    "../vector"
+)
+
+type plyProperty struct {
+    name string
+    valueType string
+    // Type of the length before a list's values, empty for single values
+    countType string
+}
+
+type plyElement struct {
+    name string
+    count int
+    properties []plyProperty
+}
+
+var plyTypeSizes = map[string]int{
+    "char": 1, "int8": 1, "uchar": 1, "uint8": 1,
+    "short": 2, "int16": 2, "ushort": 2, "uint16": 2,
+    "int": 4, "int32": 4, "uint": 4, "uint32": 4,
+    "float": 4, "float32": 4, "double": 8, "float64": 8,
+}
+
+// Reads the values of a PLY body, as whitespace separated text or packed
+// binary in the given byte order
+type plyReader struct {
+    words *bufio.Scanner
+    bytes *bufio.Reader
+    order binary.ByteOrder
+    buffer [8]byte
+}
+
+func (reader *plyReader) read(valueType string) (float64, error) {
+    if reader.words != nil {
+        if !reader.words.Scan() {
+            if err := reader.words.Err(); err != nil {
+                return 0, err
+            }
+            return 0, io.ErrUnexpectedEOF
+        }
+        return strconv.ParseFloat(reader.words.Text(), 64)
+    }
+    bytes := reader.buffer[:plyTypeSizes[valueType]]
+    if _, err := io.ReadFull(reader.bytes, bytes); err != nil {
+        if err == io.EOF {
+            err = io.ErrUnexpectedEOF
+        }
+        return 0, err
+    }
+    switch valueType {
+    case "char", "int8":
+        return float64(int8(bytes[0])), nil
+    case "uchar", "uint8":
+        return float64(bytes[0]), nil
+    case "short", "int16":
+        return float64(int16(reader.order.Uint16(bytes))), nil
+    case "ushort", "uint16":
+        return float64(reader.order.Uint16(bytes)), nil
+    case "int", "int32":
+        return float64(int32(reader.order.Uint32(bytes))), nil
+    case "uint", "uint32":
+        return float64(reader.order.Uint32(bytes)), nil
+    case "float", "float32":
+        return float64(math.Float32frombits(reader.order.Uint32(bytes))), nil
+    }
+    return math.Float64frombits(reader.order.Uint64(bytes)), nil
+}
+
+// Largest value of an integer color channel type, 1 for floats
+func plyColorScale(valueType string) float64 {
+    switch valueType {
+    case "uchar", "uint8":
+        return 255
+    case "ushort", "uint16":
+        return 65535
+    }
+    return 1
+}
+
+// Reads a Stanford PLY file in ASCII or either binary byte order. Vertices
+// give positions and optionally normals, texture coordinates and colors.
+// Colors need all of red, green and blue. Faces are polygons of vertex
+// indices, other elements and properties are skipped. Like OBJ meshes
+// without normals, meshes are smooth shaded.
+func parsePly(filename string) (*Mesh, error) {
+    file, err := os.Open(filename)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    input := bufio.NewReader(file)
+    elements, reader, err := readPlyHeader(filename, input)
+    if err != nil {
+        return nil, err
+    }
+
+    mesh := &Mesh{name: filename}
+    faces := [][]int{}
+    for _, element := range elements {
+        // Where the vertex properties the mesh uses are among the values
+        columns := map[string]int{}
+        // Zero for missing channels
+        colorScales := [3]float64{}
+        for i, property := range element.properties {
+            columns[property.name] = i
+            for channel, name := range []string{"red", "green", "blue"} {
+                if property.name == name {
+                    colorScales[channel] = plyColorScale(property.valueType)
+                }
+            }
+        }
+        value := func(values [][]float64, names ...string) (float64, bool) {
+            for _, name := range names {
+                if column, ok := columns[name]; ok && len(values[column]) == 1 {
+                    return values[column][0], true
+                }
+            }
+            return 0, false
+        }
+
+        values := make([][]float64, len(element.properties))
+        for i := 0; i < element.count; i++ {
+            for j, property := range element.properties {
+                count := 1
+                if property.countType != "" {
+                    length, err := reader.read(property.countType)
+                    if err != nil || length < 0 {
+                        return nil, fmt.Errorf("%s: %s %d: malformed %s list", filename, element.name, i, property.name)
+                    }
+                    count = int(length)
+                }
+                values[j] = values[j][:0]
+                for k := 0; k < count; k++ {
+                    number, err := reader.read(property.valueType)
+                    if err != nil {
+                        return nil, fmt.Errorf("%s: %s %d: reading %s: %v", filename, element.name, i, property.name, err)
+                    }
+                    values[j] = append(values[j], number)
+                }
+            }
+
+            switch element.name {
+            case "vertex":
+                x, okX := value(values, "x")
+                y, okY := value(values, "y")
+                z, okZ := value(values, "z")
+                if !okX || !okY || !okZ {
+                    return nil, fmt.Errorf("%s: vertex %d has no x, y and z", filename, i)
+                }
+                mesh.positions = append(mesh.positions, raytracer.Vector{X: x, Y: y, Z: z}.VectorScale(SCALE_FACTOR))
+                if nx, ok := value(values, "nx"); ok {
+                    ny, _ := value(values, "ny")
+                    nz, _ := value(values, "nz")
+                    mesh.normals = append(mesh.normals, raytracer.Vector{X: nx, Y: ny, Z: nz}.Normalize())
+                }
+                if u, ok := value(values, "u", "s", "texture_u"); ok {
+                    v, _ := value(values, "v", "t", "texture_v")
+                    mesh.texcoords = append(mesh.texcoords, [2]float64{u, v})
+                }
+                if colorScales[0] != 0 && colorScales[1] != 0 && colorScales[2] != 0 {
+                    red, _ := value(values, "red")
+                    green, _ := value(values, "green")
+                    blue, _ := value(values, "blue")
+                    mesh.colors = append(mesh.colors, raytracer.Vector{X: red/colorScales[0], Y: green/colorScales[1], Z: blue/colorScales[2]})
+                }
+            case "face":
+                column, ok := columns["vertex_indices"]
+                if !ok {
+                    column, ok = columns["vertex_index"]
+                }
+                if !ok {
+                    return nil, fmt.Errorf("%s: face %d has no vertex_indices", filename, i)
+                }
+                face := make([]int, len(values[column]))
+                for k, index := range values[column] {
+                    face[k] = int(index)
+                }
+                faces = append(faces, face)
+            }
+        }
+    }
+
+    for i, face := range faces {
+        if len(face) < 3 {
+            return nil, fmt.Errorf("%s: face %d has %d vertices, expected at least 3", filename, i, len(face))
+        }
+        polygon := make([]MeshVertex, len(face))
+        for k, index := range face {
+            if index < 0 || index >= len(mesh.positions) {
+                return nil, fmt.Errorf("%s: face %d: vertex index %d out of range", filename, i, index)
+            }
+            polygon[k] = flatVertex(index)
+            // Normals and texture coordinates are given per vertex
+            if len(mesh.normals) == len(mesh.positions) {
+                polygon[k].normal = int32(index)
+            }
+            if len(mesh.texcoords) == len(mesh.positions) {
+                polygon[k].texcoord = int32(index)
+            }
+        }
+        mesh.triangles = append(mesh.triangles, mesh.triangulate(polygon)...)
+    }
+    smooth := make([]bool, len(mesh.triangles))
+    for i := range smooth {
+        smooth[i] = true
+    }
+    mesh.addSmoothNormals(smooth)
+    return mesh, nil
+}
+
+// Parses the header up to end_header, returning the elements in file order
+// and a reader for the body in the header's format
+func readPlyHeader(filename string, input *bufio.Reader) ([]plyElement, *plyReader, error) {
+    elements := []plyElement{}
+    var reader *plyReader
+    for lineNumber := 1; ; lineNumber++ {
+        line, err := input.ReadString('\n')
+        if err != nil && (err != io.EOF || line == "") {
+            return nil, nil, fmt.Errorf("%s: header ends without end_header", filename)
+        }
+        headerError := func(format string, a ...interface{}) error {
+            return &SceneError{filename: filename, line: lineNumber, column: 1, message: fmt.Sprintf(format, a...)}
+        }
+        fields := strings.Fields(line)
+        if lineNumber == 1 {
+            if len(fields) != 1 || fields[0] != "ply" {
+                return nil, nil, headerError("not a PLY file")
+            }
+            continue
+        }
+        if len(fields) == 0 {
+            continue
+        }
+
+        switch fields[0] {
+        case "format":
+            if len(fields) != 3 {
+                return nil, nil, headerError("format expects a type and a version")
+            }
+            switch fields[1] {
+            case "ascii":
+                words := bufio.NewScanner(input)
+                words.Split(bufio.ScanWords)
+                reader = &plyReader{words: words}
+            case "binary_little_endian":
+                reader = &plyReader{bytes: input, order: binary.LittleEndian}
+            case "binary_big_endian":
+                reader = &plyReader{bytes: input, order: binary.BigEndian}
+            default:
+                return nil, nil, headerError("unknown format %q", fields[1])
+            }
+        case "element":
+            if len(fields) != 3 {
+                return nil, nil, headerError("element expects a name and a count")
+            }
+            count, err := strconv.Atoi(fields[2])
+            if err != nil || count < 0 {
+                return nil, nil, headerError("element %s has a bad count %q", fields[1], fields[2])
+            }
+            elements = append(elements, plyElement{name: fields[1], count: count})
+        case "property":
+            if len(elements) == 0 {
+                return nil, nil, headerError("property before any element")
+            }
+            property := plyProperty{}
+            if len(fields) == 5 && fields[1] == "list" {
+                property = plyProperty{countType: fields[2], valueType: fields[3], name: fields[4]}
+                if _, ok := plyTypeSizes[property.countType]; !ok {
+                    return nil, nil, headerError("unknown property type %q", property.countType)
+                }
+            } else if len(fields) == 3 {
+                property = plyProperty{valueType: fields[1], name: fields[2]}
+            } else {
+                return nil, nil, headerError("malformed property")
+            }
+            if _, ok := plyTypeSizes[property.valueType]; !ok {
+                return nil, nil, headerError("unknown property type %q", property.valueType)
+            }
+            last := &elements[len(elements)-1]
+            last.properties = append(last.properties, property)
+        case "end_header":
+            if reader == nil {
+                return nil, nil, headerError("end_header before format")
+            }
+            return elements, reader, nil
+        case "comment", "obj_info":
+        default:
+            return nil, nil, headerError("unknown header keyword %q", fields[0])
+        }
+    }
+}
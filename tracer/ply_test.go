@@ -0,0 +1,101 @@
+package tracer
+
+import (
+    "bytes"
+    "encoding/binary"
+    "math"
+    "path/filepath"
+    "testing"
+    "../vector"
+)
+
+func TestPlyMeshes(t *testing.T) {
+    header := func(format string) string {
+        return "ply\nformat " + format + " 1.0\ncomment a quad\nelement vertex 4\nproperty float x\nproperty float y\nproperty float z\n" +
+            "property uchar red\nproperty uchar green\nproperty uchar blue\nproperty float confidence\n" +
+            "element face 1\nproperty list uchar int vertex_indices\nelement edge 0\nproperty int vertex1\nend_header\n"
+    }
+    files := map[string]string{
+        "ascii.ply": header("ascii") + "0 0 0 255 0 0 1\n1 0 0 0 255 0 1\n1 1 0 0 0 255 1\n0 1 0 255 255 255 1\n4 0 1 2 3\n",
+        // Vertex colors are the diffuse color, so a mesh shows without a mat
+        "red_quad.ply": "ply\nformat ascii 1.0\nelement vertex 4\nproperty float x\nproperty float y\nproperty float z\n" +
+            "property uchar red\nproperty uchar green\nproperty uchar blue\nelement face 1\nproperty list uchar int vertex_indices\nend_header\n" +
+            "-60 -60 0 255 0 0\n60 -60 0 255 0 0\n60 60 0 255 0 0\n-60 60 0 255 0 0\n4 0 1 2 3\n",
+        "colored.txt": "cam 0 0 100 -50 -50 0 50 -50 0 -50 50 0 50 50 0\nres 4 4\nltd 0 0 -1 1 1 1\nmesh red_quad.ply\n",
+        // Red alone is not a color
+        "red.ply": "ply\nformat ascii 1.0\nelement vertex 3\nproperty float x\nproperty float y\nproperty float z\nproperty uchar red\n" +
+            "element face 1\nproperty list uchar int vertex_indices\nend_header\n0 0 0 255\n1 0 0 255\n0 1 0 255\n3 0 1 2\n",
+    }
+    invalid := map[string]string{
+        "truncated.ply": header("binary_little_endian") + "\x00\x00",
+        "range.ply": "ply\nformat ascii 1.0\nelement vertex 1\nproperty float x\nproperty float y\nproperty float z\nelement face 1\nproperty list uchar int vertex_indices\nend_header\n0 0 0\n3 0 0 1\n",
+        "header.ply": "ply\nformat ascii 1.0\nelement vertex 1\nproperty quad x\nend_header\n",
+    }
+    quads := []string{"ascii.ply"}
+    for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+        var body bytes.Buffer
+        name := map[binary.ByteOrder]string{binary.LittleEndian: "binary_little_endian", binary.BigEndian: "binary_big_endian"}[order]
+        body.WriteString(header(name))
+        corners := [4][3]float32{{0, 0, 0}, {1, 0, 0}, {1, 1, 0}, {0, 1, 0}}
+        colors := [4][3]uint8{{255, 0, 0}, {0, 255, 0}, {0, 0, 255}, {255, 255, 255}}
+        for i := range corners {
+            binary.Write(&body, order, corners[i])
+            binary.Write(&body, order, colors[i])
+            binary.Write(&body, order, float32(1))
+        }
+        binary.Write(&body, order, uint8(4))
+        binary.Write(&body, order, [4]int32{0, 1, 2, 3})
+        files[name + ".ply"] = body.String()
+        quads = append(quads, name + ".ply")
+    }
+    for name, contents := range invalid {
+        files[name] = contents
+    }
+    directory := writeFixtures(t, files)
+
+    for _, name := range quads {
+        mesh, err := parsePly(filepath.Join(directory, name))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(mesh.positions) != 4 || mesh.positions[2] != (raytracer.Vector{X:SCALE_FACTOR, Y:SCALE_FACTOR, Z:0}) || len(mesh.triangles) != 2 {
+            t.Errorf("%s: expected a quad split in two, got %+v", name, mesh)
+        }
+        if len(mesh.colors) != 4 || mesh.colors[1] != (raytracer.Vector{X:0, Y:1, Z:0}) {
+            t.Errorf("%s: expected colors scaled to [0, 1], got %+v", name, mesh.colors)
+        }
+        // Vertex colors tint the diffuse color where the triangle is hit
+        mesh.transform, mesh.material = identityTransform(), &Material{diffuse: raytracer.Vector{X:1, Y:1, Z:1}}
+        ray := Ray{start: raytracer.Vector{X:2, Y:0.5, Z:10}, direction: raytracer.Vector{X:0, Y:0, Z:-1}}
+        hits := 0
+        for i := range mesh.triangles {
+            if hit, ok := (Triangle{mesh: mesh, index: i}).Intersect(ray, 0, math.MaxFloat64); ok {
+                hits++
+                if !hit.hasVertexColor || hit.vertexColor.X < 0.7 || hit.shadingNormal.Z != 1 {
+                    t.Errorf("%s: expected a mostly red smooth hit near the first corner, got %+v", name, hit)
+                }
+            }
+        }
+        if hits != 1 {
+            t.Errorf("%s: expected one triangle hit, got %d", name, hits)
+        }
+    }
+
+    scene, err := ParseScene(filepath.Join(directory, "colored.txt"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if color := NewRenderer(scene, DefaultRenderOptions()).Render().At(2, 2); color.X < 0.9 || color.Y != 0 || color.Z != 0 {
+        t.Errorf("Expected a red pixel from a colored mesh without a mat, got %v", color)
+    }
+
+    if mesh, err := parsePly(filepath.Join(directory, "red.ply")); err != nil || len(mesh.colors) != 0 {
+        t.Errorf("Expected a mesh without vertex colors, got %v and %+v", err, mesh)
+    }
+
+    for name := range invalid {
+        if _, err := parsePly(filepath.Join(directory, name)); err == nil {
+            t.Errorf("Expected an error for %s", name)
+        }
+    }
+}
@@ -0,0 +1,448 @@
+// Package tracer parses scene files and ray traces them into images. The
+// raytracer command is a thin wrapper around it.
+package tracer
+
+import (
+    "math"
+    "../vector"
+)
+
+type Material struct {
+    ambient raytracer.Vector
+    diffuse raytracer.Vector
+    specular raytracer.Vector
+    shininess float64
+    reflective raytracer.Vector
+    // Light let through the surface and the index of refraction it bends by,
+    // zero transmission for opaque materials
+    transmission raytracer.Vector
+    ior float64
+    // Multiplies the diffuse color at the hit's texture coordinates
+    diffuseTexture *Texture
+}
+
+func (material *Material) isTransparent() bool {
+    return material.transmission != emptyVector()
+}
+
+type DirectionalLight struct {
+    direction raytracer.Vector
+    color raytracer.Vector
+}
+
+type PointLight struct {
+    position raytracer.Vector
+    color raytracer.Vector
+    // 0 for no falloff, 1 for linear and 2 for quadratic
+    falloff int
+}
+
+// Transform keeps an object to world matrix together with its inverse
+type Transform struct {
+    objectToWorld raytracer.Matrix4
+    worldToObject raytracer.Matrix4
+}
+
+type Ray struct {
+    start raytracer.Vector
+    direction raytracer.Vector
+}
+
+// Hit describes where a ray meets a shape, in world space
+type Hit struct {
+    t float64
+    position raytracer.Vector
+    // The normal of the surface itself, and the one used for lighting
+    geometricNormal raytracer.Vector
+    shadingNormal raytracer.Vector
+    u float64
+    v float64
+    // Interpolated from the mesh's vertex colors when it has them
+    vertexColor raytracer.Vector
+    hasVertexColor bool
+    material *Material
+    shape Shape
+}
+
+// Shapes only find intersections, the Integrator shades them
+type Shape interface {
+    // Nearest intersection with tMin < t < tMax
+    Intersect(ray Ray, tMin float64, tMax float64) (Hit, bool)
+    // World space bounding box
+    bounds() AABB
+    // Index of the shape in Scene.shapes
+    ID() int
+}
+
+// The triangle at index in its mesh
+type Triangle struct {
+    id int
+    mesh *Mesh
+    index int
+}
+
+type Sphere struct {
+    id int
+    center raytracer.Vector
+    radius float64
+    transform Transform
+    material *Material
+}
+
+const (
+    SCALE_FACTOR = 10.0
+    // Shape ID that excludes nothing
+    NO_SHAPE = -1
+    // World space offset of shadow ray origins from the surface
+    SHADOW_EPSILON = 1e-4*SCALE_FACTOR
+)
+
+func floatToRGB(color float64) uint8 {
+    return uint8(math.Floor(color*255))
+}
+
+
+func getRayIntersection(t float64, ray Ray) raytracer.Vector {
+    return ray.start.VectorAdd(ray.direction.VectorScale(t))
+}
+
+func identityTransform() Transform {
+    return Transform{objectToWorld: raytracer.Identity(), worldToObject: raytracer.Identity()}
+}
+
+// Fails on singular matrices
+func newTransform(objectToWorld raytracer.Matrix4) (Transform, bool) {
+    worldToObject, ok := objectToWorld.Inverse()
+    return Transform{objectToWorld: objectToWorld, worldToObject: worldToObject}, ok
+}
+
+// The new matrix applies to objects after the current transform, in world
+// space, the order xft always had: M = matrix * M_current
+func (transform Transform) compose(matrix raytracer.Matrix4) (Transform, bool) {
+    return newTransform(matrix.Multiply(transform.objectToWorld))
+}
+
+// Directions are not normalized, so t is the same in both spaces
+func (transform Transform) rayToObject(ray Ray) Ray {
+    return Ray{
+        start: transform.worldToObject.TransformPoint(ray.start),
+        direction: transform.worldToObject.TransformDirection(ray.direction),
+    }
+}
+
+func (transform Transform) normalToWorld(normal raytracer.Vector) raytracer.Vector {
+    return transform.objectToWorld.TransformNormal(normal)
+}
+
+//p(t) = e + t(s-e)
+func computeRay(start raytracer.Vector, pixel raytracer.Vector) Ray {
+    return Ray{start: start, direction: pixel.VectorSub(start)}
+}
+
+func emptyVector() raytracer.Vector {
+    return raytracer.Vector{X:0, Y:0, Z:0}
+}
+
+// Unit direction from the point towards the light, and the light's color
+// once its falloff over that distance is applied
+func (scene *Scene) pointLightAt(light PointLight, point raytracer.Vector) (raytracer.Vector, raytracer.Vector) {
+    if scene.legacyPointLights {
+        return light.position.Normalize(), light.color
+    }
+    toLight := light.position.VectorSub(point)
+    distance := math.Sqrt(toLight.DotProduct(toLight))
+    // Falloff is measured in scene file units
+    attenuation := 1/math.Pow(distance/SCALE_FACTOR, float64(light.falloff))
+    return toLight.VectorDiv(distance), light.color.VectorScale(attenuation)
+}
+
+func calculateDiffuseColor(diffuse raytracer.Vector, normal raytracer.Vector, direction raytracer.Vector, lightColor raytracer.Vector) raytracer.Vector {
+    theta := math.Max(0, normal.DotProduct(direction))
+    return diffuse.VectorScale(theta).VectorMult(lightColor)
+}
+
+func (scene *Scene) calculateAmbientColor(ambient raytracer.Vector) raytracer.Vector {
+    ambientColor := emptyVector()
+    for _, light := range scene.directionalLights {
+        ambientColor = ambientColor.VectorAdd(light.color.VectorMult(ambient))
+    }
+    for _, light := range scene.pointLights {
+        ambientColor = ambientColor.VectorAdd(light.color.VectorMult(ambient))
+    }
+    ambientColor = ambientColor.VectorAdd(scene.ambientLight.VectorMult(ambient))
+    return ambientColor
+}
+
+// R = 2N(I . N) - I
+func getReflectedLight(light raytracer.Vector, normal raytracer.Vector) raytracer.Vector {
+    lightDotNormal := math.Max(0.0, light.DotProduct(normal))
+    return normal.VectorScale(2.0*lightDotNormal).VectorSub(light)
+}
+
+func calculateSpecularColor(material Material, intersection raytracer.Vector, normal raytracer.Vector, ray Ray, incomingLight raytracer.Vector, lightColor raytracer.Vector) raytracer.Vector {
+    reflectedLight := getReflectedLight(incomingLight, normal).Normalize()
+    directionToViewer := ray.start.VectorSub(intersection).Normalize()
+    specularTerm := math.Max(0, reflectedLight.DotProduct(directionToViewer))
+    return material.specular.VectorMult(lightColor.VectorScale(math.Pow(specularTerm, material.shininess)))
+}
+
+// Ray leaving the surface at point, started SHADOW_EPSILON off it on the
+// side the direction points to so the surface does not hit itself
+func offsetRay(point raytracer.Vector, normal raytracer.Vector, direction raytracer.Vector) Ray {
+    offset := normal.VectorScale(SHADOW_EPSILON)
+    if normal.DotProduct(direction) < 0 {
+        offset = offset.VectorScale(-1)
+    }
+    return Ray{start: point.VectorAdd(offset), direction: direction}
+}
+
+// Fraction of a light distance away along the unit direction that reaches
+// the point. Opaque shapes in between block it, transparent ones tint it.
+func (scene *Scene) lightTransmittance(point raytracer.Vector, normal raytracer.Vector, direction raytracer.Vector, distance float64) raytracer.Vector {
+    return scene.bvh.transmittance(offsetRay(point, normal, direction), 0, distance)
+}
+
+// Lights blocked by other shapes only leave their ambient term
+func (scene *Scene) calculateColor(material Material, intersection raytracer.Vector, normal raytracer.Vector, ray Ray) raytracer.Vector {
+    color := scene.calculateAmbientColor(material.ambient)
+    for _, light := range scene.directionalLights {
+        // The light travels along its direction, so it is the other way
+        toLight := light.direction.VectorScale(-1).Normalize()
+        transmittance := scene.lightTransmittance(intersection, normal, toLight, math.MaxFloat64)
+        if transmittance == emptyVector() {
+            continue
+        }
+        lightColor := light.color.VectorMult(transmittance)
+        color = color.VectorAdd(calculateDiffuseColor(material.diffuse, normal, toLight, lightColor))
+        color = color.VectorAdd(calculateSpecularColor(material, intersection, normal, ray, toLight, lightColor))
+    }
+    for _, light := range scene.pointLights {
+        toLight := light.position.VectorSub(intersection)
+        distance := math.Sqrt(toLight.DotProduct(toLight))
+        transmittance := scene.lightTransmittance(intersection, normal, toLight.VectorDiv(distance), distance)
+        if transmittance == emptyVector() {
+            continue
+        }
+        direction, lightColor := scene.pointLightAt(light, intersection)
+        lightColor = lightColor.VectorMult(transmittance)
+        color = color.VectorAdd(calculateDiffuseColor(material.diffuse, normal, direction, lightColor))
+        color = color.VectorAdd(calculateSpecularColor(material, intersection, normal, ray, direction, lightColor))
+    }
+    return color
+}
+
+//func traceBack(shape Shape, intersection raytracer.Vector, normal raytracer.Vector, depth int) raytracer.Vector {
+//    for light, _ := range pointLights {
+//        incomingLight := light
+//        reflected := reflectionLight(incomingLight, normal)
+//        outgoingLight := reflected.VectorSub(intersection)
+//        reflectedRay := computeRay(incomingLight, intersection)
+//
+//    }
+//}
+
+// R = I - 2N(I . N)
+func reflectionLight(incoming raytracer.Vector, normal raytracer.Vector) raytracer.Vector {
+    d := incoming.DotProduct(normal)
+    return incoming.VectorSub(normal.VectorScale(2*d))
+}
+
+// Snell's law for the unit direction I through a surface with unit normal N
+// facing it, where eta is the ratio of the indices of refraction on either
+// side: T = eta I + (eta cos(i) - cos(t)) N. Also returns cos(t), and false
+// on total internal reflection.
+func refractionLight(incoming raytracer.Vector, normal raytracer.Vector, eta float64) (raytracer.Vector, float64, bool) {
+    cosIncident := -incoming.DotProduct(normal)
+    sin2Transmitted := eta*eta*(1 - cosIncident*cosIncident)
+    if sin2Transmitted > 1 {
+        return emptyVector(), 0, false
+    }
+    cosTransmitted := math.Sqrt(1 - sin2Transmitted)
+    transmitted := incoming.VectorScale(eta).VectorAdd(normal.VectorScale(eta*cosIncident - cosTransmitted))
+    return transmitted, cosTransmitted, true
+}
+
+// Schlick's approximation of the Fresnel reflectance between a medium with
+// index of refraction ior and air, where cosine is on the side of the air
+func schlickReflectance(cosine float64, ior float64) float64 {
+    // Matching indices leave no boundary to reflect from
+    if ior == 1 {
+        return 0
+    }
+    r0 := math.Pow((1 - ior)/(1 + ior), 2)
+    return r0 + (1 - r0)*math.Pow(1 - cosine, 5)
+}
+
+func isInsideTriangle(a raytracer.Vector, b raytracer.Vector, c raytracer.Vector, intersection raytracer.Vector, normal raytracer.Vector) bool {
+    edge0 := b.VectorSub(a)
+    c0 := intersection.VectorSub(a)
+    if (normal.DotProduct(edge0.CrossProduct(c0))) < 0 {
+        return false
+    }
+    edge1 := c.VectorSub(b)
+    c1 := intersection.VectorSub(b)
+    if (normal.DotProduct(edge1.CrossProduct(c1))) < 0 {
+        return false
+    }
+
+    edge2 := a.VectorSub(c)
+    c2 := intersection.VectorSub(c)
+    if (normal.DotProduct(edge2.CrossProduct(c2))) < 0 {
+        return false
+    }
+    return true
+}
+
+func (triangle Triangle) vertices() [3]MeshVertex {
+    return triangle.mesh.triangles[triangle.index]
+}
+
+// Object space corners
+func (triangle Triangle) corners() (raytracer.Vector, raytracer.Vector, raytracer.Vector) {
+    return triangle.mesh.corners(triangle.index)
+}
+
+// http://www.scratchapixel.com/lessons/3d-basic-lessons/lesson-9-ray-triangle-intersection/ray-triangle-intersection-geometric-solution/
+func (triangle Triangle) Intersect(ray Ray, tMin float64, tMax float64) (Hit, bool) {
+    mesh := triangle.mesh
+    a, b, c := triangle.corners()
+    objectRay := mesh.transform.rayToObject(ray)
+    // n = (V1 - V0) x (V2 - V0)
+    edge1 := b.VectorSub(a)
+    edge2 := c.VectorSub(a)
+    surfaceNormal := edge1.CrossProduct(edge2).Normalize()
+    denominator := surfaceNormal.DotProduct(objectRay.direction)
+    // Ray parallel to the triangle's plane
+    if denominator == 0 {
+        return Hit{}, false
+    }
+    // The plane holds every p with n . p = n . V0
+    t := surfaceNormal.DotProduct(a.VectorSub(objectRay.start))/denominator
+    if t <= tMin || t >= tMax {
+        return Hit{}, false
+    }
+    objectIntersection := getRayIntersection(t, objectRay)
+    if !isInsideTriangle(a, b, c, objectIntersection, surfaceNormal) {
+        return Hit{}, false
+    }
+
+    // Barycentric weights of b and c
+    offset := objectIntersection.VectorSub(a)
+    d00, d01, d11 := edge1.DotProduct(edge1), edge1.DotProduct(edge2), edge2.DotProduct(edge2)
+    d20, d21 := offset.DotProduct(edge1), offset.DotProduct(edge2)
+    denominator = d00*d11 - d01*d01
+    weights := [3]float64{0, (d11*d20 - d01*d21)/denominator, (d00*d21 - d01*d20)/denominator}
+    weights[0] = 1 - weights[1] - weights[2]
+    normal := mesh.transform.normalToWorld(surfaceNormal)
+    shadingNormal := normal
+    u, v := weights[1], weights[2]
+    vertices := triangle.vertices()
+    if vertices[0].normal >= 0 && vertices[1].normal >= 0 && vertices[2].normal >= 0 {
+        interpolated := emptyVector()
+        for i, vertex := range vertices {
+            interpolated = interpolated.VectorAdd(mesh.normals[vertex.normal].VectorScale(weights[i]))
+        }
+        shadingNormal = mesh.transform.normalToWorld(interpolated)
+    }
+    if vertices[0].texcoord >= 0 && vertices[1].texcoord >= 0 && vertices[2].texcoord >= 0 {
+        u, v = 0, 0
+        for i, vertex := range vertices {
+            u += weights[i]*mesh.texcoords[vertex.texcoord][0]
+            v += weights[i]*mesh.texcoords[vertex.texcoord][1]
+        }
+    }
+    hit := Hit{
+        t: t,
+        position: getRayIntersection(t, ray),
+        geometricNormal: normal,
+        shadingNormal: shadingNormal,
+        u: u,
+        v: v,
+        material: mesh.materialAt(triangle.index),
+        shape: triangle,
+    }
+    if len(mesh.colors) > 0 {
+        hit.hasVertexColor = true
+        for i, vertex := range vertices {
+            hit.vertexColor = hit.vertexColor.VectorAdd(mesh.colors[vertex.position].VectorScale(weights[i]))
+        }
+    }
+    return hit, true
+}
+
+func (triangle Triangle) ID() int {
+    return triangle.id
+}
+
+func (triangle Triangle) bounds() AABB {
+    a, b, c := triangle.corners()
+    box := emptyAABB().extend(a).extend(b).extend(c)
+    return box.transform(triangle.mesh.transform.objectToWorld)
+}
+
+// Formula from http://www.csee.umbc.edu/~olano/435f02/ray-sphere.html
+func (sphere Sphere) Intersect(ray Ray, tMin float64, tMax float64) (Hit, bool) {
+    objectRay := sphere.transform.rayToObject(ray)
+    a := objectRay.direction.DotProduct(objectRay.direction) 
+    b := 2.0 * objectRay.direction.DotProduct(objectRay.start.VectorSub(sphere.center)) 
+    c := objectRay.start.VectorSub(sphere.center).DotProduct(objectRay.start.VectorSub(sphere.center)) - math.Pow(sphere.radius, 2)
+    discriminant := math.Pow(b, 2) - 4.0*a*c
+
+    if discriminant < 0 {
+        return Hit{}, false
+    }
+
+    // The far side counts when the near one is out of range
+    t := (-b - math.Sqrt(discriminant))/(2*a)
+    if t <= tMin {
+        t = (-b + math.Sqrt(discriminant))/(2*a)
+    }
+    if t <= tMin || t >= tMax {
+        return Hit{}, false
+    }
+
+    objectNormal := getRayIntersection(t, objectRay).VectorSub(sphere.center).VectorDiv(sphere.radius)
+    normal := sphere.transform.normalToWorld(objectNormal)
+    return Hit{
+        t: t,
+        position: getRayIntersection(t, ray),
+        geometricNormal: normal,
+        shadingNormal: normal,
+        u: 0.5 + math.Atan2(objectNormal.Z, objectNormal.X)/(2*math.Pi),
+        v: math.Acos(math.Max(-1, math.Min(1, objectNormal.Y)))/math.Pi,
+        material: sphere.material,
+        shape: sphere,
+    }, true
+}
+
+func (sphere Sphere) ID() int {
+    return sphere.id
+}
+
+func (sphere Sphere) bounds() AABB {
+    radius := raytracer.Vector{X:sphere.radius, Y:sphere.radius, Z:sphere.radius}
+    box := AABB{min: sphere.center.VectorSub(radius), max: sphere.center.VectorAdd(radius)}
+    return box.transform(sphere.transform.objectToWorld)
+}
+
+func clip(color *raytracer.Vector) {
+    if color.X > 1.0 {
+        color.X = 1.0
+    }
+    if color.Y > 1.0 {
+        color.Y = 1.0
+    }
+    if color.Z > 1.0 {
+        color.Z = 1.0
+    }
+    if color.X < 0 {
+        color.X = 0
+    }
+    if color.Y < 0 {
+        color.Y = 0
+    }
+    if color.Z < 0 {
+        color.Z = 0
+    }
+}
+
@@ -0,0 +1,984 @@
+package tracer
+
+import (
+    "bytes"
+    "fmt"
+    "image/png"
+    "math"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+    "../vector"
+)
+
+// Writes each file under a new temporary directory, by its slash separated
+// path there, and returns the directory
+func writeFixtures(t *testing.T, files map[string]string) string {
+    t.Helper()
+    directory := t.TempDir()
+    for name, contents := range files {
+        path := filepath.Join(directory, filepath.FromSlash(name))
+        if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+            t.Fatal(err)
+        }
+        if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return directory
+}
+
+func TestBasic(t *testing.T) {
+    if (emptyVector().X != 0) {
+        t.Error("Failed")
+    }
+}
+
+func TestScenesAreIndependent(t *testing.T) {
+    first, err := interpretScene("test.txt", []string{"ltp 1 2 3 1 1 1", "sph 0 0 0 1"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    second, err := interpretScene("test.txt", []string{"lta 0.1 0.1 0.1"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(first.shapes) != 1 || len(first.pointLights) != 1 {
+        t.Error("Expected one shape and one point light in the first scene")
+    }
+    if len(second.shapes) != 0 || len(second.pointLights) != 0 {
+        t.Error("Second scene shares state with the first")
+    }
+}
+
+func TestBVHMatchesLinearSearch(t *testing.T) {
+    lines := []string{"mat 0.1 0.1 0.1 0.5 0.5 0.5 0.5 0.5 0.5 10 0 0 0"}
+    for i := 0; i < 60; i++ {
+        x, y := float64(i%8)*12 - 45, float64(i/8)*12 - 45
+        lines = append(lines, fmt.Sprintf("sph %v %v %v 4", x, y, -float64(i%5)*20))
+        lines = append(lines, fmt.Sprintf("tri %v %v -150 %v %v -150 %v %v -150", x, y, x+10, y, x, y+10))
+    }
+    scene, err := interpretScene("test.txt", lines)
+    if err != nil {
+        t.Fatal(err)
+    }
+    eye := raytracer.Vector{X:0, Y:0, Z:1000}
+    for i := 0; i < 400; i++ {
+        target := raytracer.Vector{X:float64(i%20)*50 - 500, Y:float64(i/20)*50 - 500, Z:0}
+        ray := computeRay(eye, target)
+        expected := math.MaxFloat64
+        for _, shape := range scene.shapes {
+            if hit, ok := shape.Intersect(ray, 0, expected); ok {
+                expected = hit.t
+            }
+        }
+        actual, isHit := scene.bvh.closestHit(ray, 0, math.MaxFloat64, NO_SHAPE)
+        if isHit != (expected != math.MaxFloat64) || (isHit && actual.t != expected) {
+            t.Errorf("Ray through %v: expected t %v, got %v (hit %v)", target, expected, actual.t, isHit)
+        }
+    }
+}
+
+func TestWorkerCountDoesNotChangeImage(t *testing.T) {
+    scene, err := interpretScene("test.txt", []string{
+        "cam 0 0 100 -50 -50 0 50 -50 0 -50 50 0 50 50 0",
+        "ltp 200 200 200 1 1 1",
+        "mat 0.1 0 0 1 0 0 0.8 0.8 0.8 16 0.5 0.5 0.5",
+        "sph -20 0 -75 25",
+        "sph 20 10 -100 25",
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    options := DefaultRenderOptions()
+    options.Width, options.Height, options.TileSize = 64, 48, 10
+    options.Workers = 1
+    serial := NewRenderer(scene, options).Render()
+    options.Workers = 7
+    parallel := NewRenderer(scene, options).Render()
+    if !bytes.Equal(serial.toRGBA().Pix, parallel.toRGBA().Pix) {
+        t.Error("Images rendered with 1 and 7 workers differ")
+    }
+}
+
+func TestLibraryInterface(t *testing.T) {
+    directory := t.TempDir()
+    filename := filepath.Join(directory, "scene.txt")
+    if err := os.WriteFile(filename, []byte("cam 0 0 100 -50 -50 0 50 -50 0 -50 50 0 50 50 0\nres 20 10\nlta 1 1 1\nmat 1 0 0 0 0 0 0 0 0 1 0 0 0\nsph 0 0 0 10\n"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    scene, err := ParseScene(filename)
+    if err != nil {
+        t.Fatal(err)
+    }
+    options := DefaultRenderOptions()
+    options.TileSize = 8
+    progress := map[string]int{}
+    options.Progress = func(stage string, done int, total int) {
+        progress[stage] = done
+        if done > total {
+            t.Errorf("%s: %d of %d tiles done", stage, done, total)
+        }
+    }
+    renderer := NewRenderer(scene, options)
+    framebuffer := renderer.Render()
+    if width, height := framebuffer.Size(); width != 20 || height != 10 || framebuffer.At(10, 5).X != 1 {
+        t.Errorf("Expected a 20x10 image with a red center, got %dx%d and %v", width, height, framebuffer.At(10, 5))
+    }
+    if progress["Rendered"] != 6 || renderer.Stats().BounceDepths[0] != 200 {
+        t.Errorf("Expected progress up to 6 tiles and 200 camera rays, got %v and %+v", progress, renderer.Stats())
+    }
+    options.Samples, options.PixelFilter = -1, "sinc"
+    if options.Validate() == nil {
+        t.Error("Expected negative samples to be rejected")
+    }
+    options.Samples = 0
+    if options.Validate() == nil {
+        t.Error("Expected an unknown filter to be rejected")
+    }
+}
+
+func TestSceneErrors(t *testing.T) {
+    cases := map[string]string{
+        "mat 0.1 0 0 1 0 0 0.8x 0.8 0.8 16 0.7 0.7 0.7": `scene8.txt:2:19: expected number, got "0.8x"`,
+        "sph 0 0 -50": "scene8.txt:2:1: sph expects 4 arguments, got 3",
+        "  sphere 0 0 -50 45": `scene8.txt:2:3: unknown command "sphere"`,
+    }
+    for line, expected := range cases {
+        _, err := interpretScene("scene8.txt", []string{"lta 0.1 0.1 0.1", line})
+        if err == nil || err.Error() != expected {
+            t.Errorf("%q: expected error %q, got %v", line, expected, err)
+        }
+    }
+}
+
+func TestSceneComments(t *testing.T) {
+    scene, err := interpretScene("test.txt", []string{
+        "# mat 1 1 1",
+        "sph 0 0 -50 45 # trailing comment",
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(scene.shapes) != 1 {
+        t.Errorf("Expected one sphere, got %d", len(scene.shapes))
+    }
+}
+
+func TestScaleAndRotateTransforms(t *testing.T) {
+    scene, err := interpretScene("test.txt", []string{
+        "xft 10 0 0",
+        "xfs 2 1 1",
+        "sph 0 0 0 1",
+        "xfz",
+        "xfr 0 0 90",
+        "sph 1 0 0 0.5",
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, shape := range scene.shapes {
+        sphere := shape.(Sphere)
+        // Rays along the x and y axes towards the origin
+        xRay := Ray{start: raytracer.Vector{X:1000, Y:0, Z:0}, direction: raytracer.Vector{X:-1, Y:0, Z:0}}
+        yRay := Ray{start: raytracer.Vector{X:0, Y:1000, Z:0}, direction: raytracer.Vector{X:0, Y:-1, Z:0}}
+        if sphere.radius == 10 {
+            // The scale applies on top of the translation before it, so
+            // the ellipsoid is centered at x = 200 with a semi-axis of 20
+            hit, _ := sphere.Intersect(xRay, 0, math.MaxFloat64)
+            if math.Abs(hit.t - 780) > 1e-9 {
+                t.Errorf("Expected the scaled sphere at t = 780, got %v", hit.t)
+            }
+            if math.Abs(hit.shadingNormal.X - 1) > 1e-9 {
+                t.Errorf("Expected normal along x, got %v", hit.shadingNormal)
+            }
+        } else {
+            // Center rotated from the x axis onto the y axis
+            if hit, _ := sphere.Intersect(yRay, 0, math.MaxFloat64); math.Abs(hit.t - 985) > 1e-9 {
+                t.Errorf("Expected the rotated sphere at t = 985, got %v", hit.t)
+            }
+        }
+    }
+}
+
+func TestMatrixInverse(t *testing.T) {
+    m := raytracer.Translation(raytracer.Vector{X:1, Y:2, Z:3}).
+        Multiply(raytracer.Rotation(raytracer.Vector{X:10, Y:20, Z:30})).
+        Multiply(raytracer.Scaling(raytracer.Vector{X:2, Y:-3, Z:4}))
+    inverse, ok := m.Inverse()
+    if !ok {
+        t.Fatal("Expected the matrix to be invertible")
+    }
+    identity := raytracer.Identity()
+    product := m.Multiply(inverse)
+    for row := 0; row < 4; row++ {
+        for column := 0; column < 4; column++ {
+            if math.Abs(product[row][column] - identity[row][column]) > 1e-12 {
+                t.Fatalf("Expected M * M^-1 to be the identity, got %v", product)
+            }
+        }
+    }
+    if det := m.Determinant(); math.Abs(det - -24) > 1e-9 {
+        t.Errorf("Expected a determinant of -24, got %v", det)
+    }
+    if _, ok := raytracer.Scaling(raytracer.Vector{X:1, Y:0, Z:1}).Inverse(); ok {
+        t.Error("Expected a singular matrix to have no inverse")
+    }
+}
+
+func TestResolution(t *testing.T) {
+    wide := "cam 0 0 100 -100 -50 0 100 -50 0 -100 50 0 100 50 0"
+    scene, err := interpretScene("test.txt", []string{wide})
+    if err != nil {
+        t.Fatal(err)
+    }
+    sizes := [][4]int{
+        {0, 0, 1000, 500},
+        {256, 0, 256, 128},
+        {0, 1080, 2160, 1080},
+        {1920, 1080, 1920, 1080},
+    }
+    for _, size := range sizes {
+        if width, height := scene.imageSize(size[0], size[1]); width != size[2] || height != size[3] {
+            t.Errorf("Expected %dx%d for %dx%d, got %dx%d", size[2], size[3], size[0], size[1], width, height)
+        }
+    }
+
+    // A square image of a wide plane only covers its middle half
+    options := DefaultRenderOptions()
+    options.Width, options.Height = 100, 100
+    renderer := NewRenderer(scene, options)
+    if renderer.uMin != 0.25 || renderer.uMax != 0.75 || renderer.vMin != 0 || renderer.vMax != 1 {
+        t.Errorf("Expected u in [0.25, 0.75], got [%v, %v] and v in [%v, %v]", renderer.uMin, renderer.uMax, renderer.vMin, renderer.vMax)
+    }
+
+    scene, err = interpretScene("test.txt", []string{wide, "res 320 240"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if width, height := scene.imageSize(0, 0); width != 320 || height != 240 {
+        t.Errorf("Expected the res command to give 320x240, got %dx%d", width, height)
+    }
+    if width, height := scene.imageSize(64, 0); width != 64 || height != 32 {
+        t.Errorf("Expected -width to override res, got %dx%d", width, height)
+    }
+    if _, err := interpretScene("test.txt", []string{"res 320 0.5"}); err == nil {
+        t.Error("Expected an error for a fractional res")
+    }
+}
+
+func TestSaveImageFormats(t *testing.T) {
+    framebuffer := newFramebuffer(3, 2)
+    framebuffer.set(0, 0, raytracer.Vector{X:2, Y:0.5, Z:-1})
+    directory := t.TempDir()
+
+    options := DefaultOutputOptions()
+    options.BitDepth = 16
+    filename := filepath.Join(directory, "out.png")
+    if err := SaveImage(framebuffer, filename, options); err != nil {
+        t.Fatal(err)
+    }
+    file, err := os.Open(filename)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer file.Close()
+    decoded, err := png.Decode(file)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if r, g, b, _ := decoded.At(0, 0).RGBA(); r != 65535 || g != 32767 || b != 0 {
+        t.Errorf("Expected a clipped 16-bit pixel, got %d %d %d", r, g, b)
+    }
+
+    filename = filepath.Join(directory, "out.ppm")
+    if err := SaveImage(framebuffer, filename, DefaultOutputOptions()); err != nil {
+        t.Fatal(err)
+    }
+    if data, _ := os.ReadFile(filename); !bytes.Equal(data[:11], []byte("P6\n3 2\n255\n")) || len(data) != 11 + 3*2*3 {
+        t.Errorf("Unexpected PPM output %q", data)
+    }
+
+    filename = filepath.Join(directory, "out.pfm")
+    if err := SaveImage(framebuffer, filename, DefaultOutputOptions()); err != nil {
+        t.Fatal(err)
+    }
+    if data, _ := os.ReadFile(filename); len(data) != 12 + 3*2*12 {
+        t.Errorf("Expected 12 header bytes and 72 bytes of floats, got %d bytes", len(data))
+    }
+
+    if err := SaveImage(framebuffer, filepath.Join(directory, "out.bmp"), DefaultOutputOptions()); err == nil {
+        t.Error("Expected an error for an unsupported extension")
+    }
+    if err := SaveImage(framebuffer, filepath.Join(directory, "missing", "out.png"), DefaultOutputOptions()); err == nil {
+        t.Error("Expected an error for a missing directory")
+    }
+}
+
+func TestSupersampling(t *testing.T) {
+    for name, pattern := range samplePatterns {
+        samples := make([][2]float64, 9)
+        pattern(samples, newSampleRandom(3, 4))
+        for _, sample := range samples {
+            if sample[0] < 0 || sample[0] >= 1 || sample[1] < 0 || sample[1] >= 1 {
+                t.Errorf("%s: sample %v is outside the unit square", name, sample)
+            }
+        }
+    }
+    // Grids fill every row, so their samples average to the pixel center
+    for _, count := range []int{2, 3, 5, 6} {
+        samples := make([][2]float64, count)
+        gridSample(samples, newSampleRandom(0, 0))
+        mean := [2]float64{}
+        for _, sample := range samples {
+            mean[0] += sample[0]/float64(count)
+            mean[1] += sample[1]/float64(count)
+        }
+        if math.Abs(mean[0] - 0.5) > 1e-9 || math.Abs(mean[1] - 0.5) > 1e-9 {
+            t.Errorf("Expected %d grid samples to average to the center, got %v", count, mean)
+        }
+    }
+    for name, filter := range pixelFilters {
+        if filter.weight(0, 0) <= 0 {
+            t.Errorf("%s: expected a positive weight at the center", name)
+        }
+        if name != "box" && math.Abs(filter.weight(filter.radius, 0)) > 1e-9 {
+            t.Errorf("%s: expected the weight to fall to zero at the radius", name)
+        }
+    }
+
+    // A uniformly lit plane gives the same color however it is sampled
+    scene, err := interpretScene("test.txt", []string{
+        "cam 0 0 100 -50 -50 0 50 -50 0 -50 50 0 50 50 0",
+        "samples 16 halton mitchell",
+        "lta 1 1 1",
+        "mat 0.5 0.5 0.5 0 0 0 0 0 0 1 0 0 0",
+        "tri -100 -100 -10 100 -100 -10 -100 100 -10",
+        "tri 100 -100 -10 100 100 -10 -100 100 -10",
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    options := DefaultRenderOptions()
+    options.Width, options.Height = 8, 8
+    renderer := NewRenderer(scene, options)
+    if renderer.options.Samples != 16 || renderer.options.SamplePattern != "halton" || renderer.options.PixelFilter != "mitchell" {
+        t.Errorf("Expected the samples command to set 16 halton samples with a mitchell filter, got %+v", renderer.options)
+    }
+    color := renderer.Render().At(4, 4)
+    if math.Abs(color.X - 0.5) > 1e-9 {
+        t.Errorf("Expected 0.5, got %v", color)
+    }
+    if _, err := interpretScene("test.txt", []string{"samples 4 poisson"}); err == nil {
+        t.Error("Expected an error for an unknown sample pattern")
+    }
+}
+
+func TestAdaptiveAntiAliasing(t *testing.T) {
+    // A triangle covering the lower left half of the image
+    scene, err := interpretScene("test.txt", []string{
+        "cam 0 0 100 -50 -50 0 50 -50 0 -50 50 0 50 50 0",
+        "lta 1 1 1",
+        "mat 1 1 1 0 0 0 0 0 0 1 0 0 0",
+        "tri -100 -100 -10 100 -100 -10 -100 100 -10",
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    options := DefaultRenderOptions()
+    options.Width, options.Height = 16, 16
+    options.Adaptive = true
+    renderer := NewRenderer(scene, options)
+    framebuffer := renderer.Render()
+
+    // Only the pixels along the diagonal edge are refined
+    if renderer.stats.RefinedPixels == 0 || renderer.stats.RefinedPixels > 2*16 {
+        t.Errorf("Expected only edge pixels to be refined, got %d", renderer.stats.RefinedPixels)
+    }
+    if renderer.stats.ExtraSamples < 4*renderer.stats.RefinedPixels {
+        t.Errorf("Expected at least 4 samples per refined pixel, got %d for %d pixels", renderer.stats.ExtraSamples, renderer.stats.RefinedPixels)
+    }
+    partial := 0
+    for _, color := range framebuffer.pixels {
+        if color.X > 0 && color.X < 1 {
+            partial++
+        }
+    }
+    if partial == 0 {
+        t.Error("Expected partially covered pixels along the edge")
+    }
+}
+
+func TestPointLightFalloff(t *testing.T) {
+    scene, err := interpretScene("test.txt", []string{
+        "ltp 0 0 10 1 1 1",
+        "ltp 0 0 10 1 1 1 1",
+        "ltp 0 0 10 1 1 1 2",
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    // The point is 5 scene units below the lights
+    point := raytracer.Vector{X:0, Y:0, Z:5*SCALE_FACTOR}
+    expected := []float64{1, 0.2, 0.04}
+    for i, light := range scene.pointLights {
+        direction, color := scene.pointLightAt(light, point)
+        if !direction.Equals(raytracer.Vector{X:0, Y:0, Z:1}) {
+            t.Errorf("Expected the light straight above the point, got %v", direction)
+        }
+        if math.Abs(color.X - expected[i]) > 1e-12 {
+            t.Errorf("Falloff %d: expected %v, got %v", light.falloff, expected[i], color.X)
+        }
+    }
+
+    scene.legacyPointLights = true
+    if direction, color := scene.pointLightAt(scene.pointLights[2], point); direction.Z != 1 || color.X != 1 {
+        t.Errorf("Expected the legacy position as direction without falloff, got %v and %v", direction, color)
+    }
+    if _, err := interpretScene("test.txt", []string{"ltp 0 0 10 1 1 1 3"}); err == nil {
+        t.Error("Expected an error for an unknown falloff")
+    }
+}
+
+func TestShadowsPerLight(t *testing.T) {
+    // A floor lit by two lights, one of them behind a sphere, with another
+    // sphere beyond the other light
+    scene, err := interpretScene("test.txt", []string{
+        "ltp -10 0 10 1 1 1",
+        "ltp 10 0 10 1 1 1",
+        "mat 0 0 0 0.5 0.5 0.5 0 0 0 1 0 0 0",
+        "tri -100 -100 0 100 -100 0 -100 100 0",
+        "sph -5 0 5 1",
+        "sph 20 0 20 1",
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    normal := raytracer.Vector{X:0, Y:0, Z:1}
+    toLight := scene.pointLights[0].position.Normalize()
+    if scene.lightTransmittance(emptyVector(), normal, toLight, scene.pointLights[0].position.DistanceTo(emptyVector())) != emptyVector() {
+        t.Error("Expected the sphere to block the first light")
+    }
+    toLight = scene.pointLights[1].position.Normalize()
+    if scene.lightTransmittance(emptyVector(), normal, toLight, scene.pointLights[1].position.DistanceTo(emptyVector())) != (raytracer.Vector{X:1, Y:1, Z:1}) {
+        t.Error("Expected the sphere beyond the second light not to block it")
+    }
+
+    material := Material{diffuse: raytracer.Vector{X:0.5, Y:0.5, Z:0.5}, shininess: 1}
+    ray := Ray{start: raytracer.Vector{X:0, Y:0, Z:100}, direction: raytracer.Vector{X:0, Y:0, Z:-1}}
+    color := scene.calculateColor(material, emptyVector(), normal, ray)
+    if expected := 0.5*math.Sqrt(0.5); math.Abs(color.X - expected) > 1e-9 {
+        t.Errorf("Expected only the second light's diffuse term %v, got %v", expected, color.X)
+    }
+}
+
+func TestDirectionalLightOnClosedShape(t *testing.T) {
+    // The light travels down -Z, onto the side of the sphere facing +Z
+    scene, err := interpretScene("test.txt", []string{
+        "ltd 0 0 -1 1 1 1",
+        "mat 0 0 0 0.5 0.5 0.5 0 0 0 1 0 0 0",
+        "sph 0 0 0 1",
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    ray := Ray{start: raytracer.Vector{X:0, Y:0, Z:100}, direction: raytracer.Vector{X:0, Y:0, Z:-1}}
+    hit, ok := scene.shapes[0].Intersect(ray, 0, math.MaxFloat64)
+    if !ok {
+        t.Fatal("Expected the ray to hit the sphere")
+    }
+    color := scene.calculateColor(*hit.material, hit.position, hit.shadingNormal, ray)
+    if math.Abs(color.X - 0.5) > 1e-9 {
+        t.Errorf("Expected the full diffuse term 0.5 facing the light, got %v", color.X)
+    }
+    // The far side faces away and gets nothing
+    back := Ray{start: raytracer.Vector{X:0, Y:0, Z:-100}, direction: raytracer.Vector{X:0, Y:0, Z:1}}
+    if hit, ok = scene.shapes[0].Intersect(back, 0, math.MaxFloat64); !ok {
+        t.Fatal("Expected the ray to hit the sphere")
+    }
+    if color := scene.calculateColor(*hit.material, hit.position, hit.shadingNormal, back); color != emptyVector() {
+        t.Errorf("Expected no light on the far side, got %v", color)
+    }
+}
+
+func TestHitRecord(t *testing.T) {
+    scene, err := interpretScene("test.txt", []string{
+        "mat 0.1 0.1 0.1 0.5 0.5 0.5 0 0 0 1 0 0 0",
+        "tri 0 0 0 1 0 0 0 1 0",
+        "mat 0.2 0.2 0.2 0.5 0.5 0.5 0 0 0 1 0 0 0",
+        "sph 0 0 -10 1",
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, shape := range scene.shapes {
+        switch shape := shape.(type) {
+        case Triangle:
+            ray := Ray{start: raytracer.Vector{X:2.5, Y:5, Z:10}, direction: raytracer.Vector{X:0, Y:0, Z:-1}}
+            hit, ok := shape.Intersect(ray, 0, math.MaxFloat64)
+            if !ok || hit.t != 10 || math.Abs(hit.u - 0.25) > 1e-12 || math.Abs(hit.v - 0.5) > 1e-12 {
+                t.Errorf("Expected the triangle at t = 10 with uv (0.25, 0.5), got %+v", hit)
+            }
+            if hit.geometricNormal.Z != 1 || hit.material.ambient.X != 0.1 || hit.shape != Shape(shape) {
+                t.Errorf("Unexpected normal, material or shape in %+v", hit)
+            }
+            if _, ok := shape.Intersect(ray, 0, 10); ok {
+                t.Error("Expected no hit at t = tMax")
+            }
+        case Sphere:
+            // From the center the far side is the only hit
+            ray := Ray{start: raytracer.Vector{X:0, Y:0, Z:-100}, direction: raytracer.Vector{X:0, Y:0, Z:1}}
+            hit, ok := shape.Intersect(ray, 0, math.MaxFloat64)
+            if !ok || math.Abs(hit.t - 10) > 1e-9 || hit.material.ambient.X != 0.2 {
+                t.Errorf("Expected the sphere from inside at t = 10, got %+v", hit)
+            }
+        }
+    }
+}
+
+func TestShapeIDsAndDeterminism(t *testing.T) {
+    lines := []string{
+        "cam 0 0 100 -50 -50 0 50 -50 0 -50 50 0 50 50 0",
+        "ltd 1 -1 -1 0.5 0.5 0.5",
+        "ltd -1 -1 -1 0.3 0.3 0.3",
+        "ltd 0 1 -1 0.2 0.2 0.2",
+        "ltp 20 20 20 0.7 0.7 0.7",
+        "mat 0.1 0.1 0.1 0.5 0.5 0.5 0.5 0.5 0.5 10 0.5 0.5 0.5",
+        "tri -40 -40 -20 40 -40 -20 -40 40 -20",
+        "tri -40 -40 -20 40 -40 -20 -40 40 -20",
+        "sph -10 0 0 10",
+        "sph 10 0 0 10",
+    }
+    scene, err := interpretScene("test.txt", lines)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // Duplicate triangles are kept
+    if len(scene.shapes) != 4 {
+        t.Fatalf("Expected 4 shapes, got %d", len(scene.shapes))
+    }
+    for i, shape := range scene.shapes {
+        if shape.ID() != i {
+            t.Errorf("Expected shape %d to have ID %d, got %d", i, i, shape.ID())
+        }
+    }
+
+    options := DefaultRenderOptions()
+    options.Width, options.Height, options.Workers = 40, 40, 3
+    first := NewRenderer(scene, options).Render()
+    for i := 0; i < 3; i++ {
+        scene, _ = interpretScene("test.txt", lines)
+        framebuffer := NewRenderer(scene, options).Render()
+        for j := range first.pixels {
+            if framebuffer.pixels[j] != first.pixels[j] {
+                t.Fatalf("Render %d differs from the first at pixel %d", i + 2, j)
+            }
+        }
+    }
+}
+
+func TestRefraction(t *testing.T) {
+    normal := raytracer.Vector{X:0, Y:0, Z:1}
+    incoming := raytracer.Vector{X:1, Y:0, Z:-1}.Normalize()
+    refracted, cosTransmitted, ok := refractionLight(incoming, normal, 1/1.5)
+    if !ok || math.Abs(refracted.X - math.Sqrt(0.5)/1.5) > 1e-12 || math.Abs(cosTransmitted + refracted.Z) > 1e-12 {
+        t.Errorf("Expected sin(t) = sin(i)/1.5, got %+v", refracted)
+    }
+    if _, _, ok := refractionLight(incoming, normal, 1.5); ok {
+        t.Error("Expected total internal reflection leaving glass at 45 degrees")
+    }
+    if reflectance := schlickReflectance(1, 1.5); math.Abs(reflectance - 0.04) > 1e-12 {
+        t.Errorf("Expected a reflectance of 0.04 head on, got %v", reflectance)
+    }
+
+    // A red wall seen through a glass ball, and lit through it
+    scene, err := interpretScene("test.txt", []string{
+        "lta 1 1 1",
+        "ltp 0 0 10 1 1 1",
+        "mat 1 0 0 1 1 1 0 0 0 1 0 0 0",
+        "tri -100 -100 -10 100 -100 -10 -100 100 -10",
+        "mat 0 0 0 0 0 0 0 0 0 1 0 0 0",
+        "mtr 0.5 1 1 1.5",
+        "sph 0 0 0 1",
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    toLight := raytracer.Vector{X:0, Y:0, Z:1}
+    transmittance := scene.lightTransmittance(raytracer.Vector{X:0, Y:0, Z:-100}, toLight, toLight, 200)
+    if transmittance != (raytracer.Vector{X:0.5, Y:1, Z:1}) {
+        t.Errorf("Expected the ball to tint the light once, got %+v", transmittance)
+    }
+    // A closed glass mesh, crossed at two of its triangles, tints it once too
+    directory := t.TempDir()
+    tetrahedron := "v 0 0 1\nv 1 -1 -1\nv -1 -1 -1\nv 0 1 -1\nf 2 3 4\nf 1 3 2\nf 1 4 3\nf 1 2 4\n"
+    if err := os.WriteFile(filepath.Join(directory, "tetrahedron.obj"), []byte(tetrahedron), 0644); err != nil {
+        t.Fatal(err)
+    }
+    glassMesh, err := interpretScene(filepath.Join(directory, "test.txt"), []string{"mtr 0.5 1 1 1.5", "obj tetrahedron.obj"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    transmittance = glassMesh.lightTransmittance(raytracer.Vector{X:1, Y:-2, Z:-100}, toLight, toLight, 200)
+    if transmittance != (raytracer.Vector{X:0.5, Y:1, Z:1}) {
+        t.Errorf("Expected the mesh to tint the light once, got %+v", transmittance)
+    }
+
+    ray := Ray{start: raytracer.Vector{X:0, Y:0, Z:100}, direction: raytracer.Vector{X:0, Y:0, Z:-1}}
+    color, isHit := newIntegrator(scene, 3, 0).radiance(ray, NO_SHAPE, 0, raytracer.Vector{X:1, Y:1, Z:1}, &rayTree{})
+    // Ambient from both lights plus tinted diffuse, less the light reflected
+    // entering and leaving the ball, and tinted by it twice
+    if expected := (2 + 0.5)*0.96*0.96*0.5*0.5; !isHit || math.Abs(color.X - expected) > 1e-9 || math.Abs(color.Y - 0.96*0.96) > 1e-9 {
+        t.Errorf("Expected %v through the ball, got %+v", expected, color)
+    }
+
+    if _, err := interpretScene("test.txt", []string{"mtr 1 1 1 0"}); err == nil {
+        t.Error("Expected an error for a zero index of refraction")
+    }
+}
+
+func TestDepthAndCutoff(t *testing.T) {
+    // Two facing mirrors that would reflect a ray forever
+    lines := []string{
+        "lta 1 1 1",
+        "mat 0.1 0.1 0.1 0 0 0 0 0 0 1 0.5 0.5 0.5",
+        "tri -100 -100 0 100 -100 0 0 100 0",
+        "tri -100 -100 -2 0 100 -2 100 -100 -2",
+    }
+    scene, err := interpretScene("test.txt", append(lines, "depth 5"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if scene.maxDepth != 5 {
+        t.Errorf("Expected depth 5 from the scene, got %d", scene.maxDepth)
+    }
+    ray := Ray{start: raytracer.Vector{X:0, Y:0, Z:-10}, direction: raytracer.Vector{X:0, Y:0, Z:1}}
+    white := raytracer.Vector{X:1, Y:1, Z:1}
+    for _, test := range []struct {
+        maxDepth int
+        minContribution float64
+        depth int
+        culled int64
+    }{{0, 0, 0, 0}, {5, 0, 5, 0}, {5, 0.1, 3, 1}} {
+        tree := rayTree{}
+        color, _ := newIntegrator(scene, test.maxDepth, test.minContribution).radiance(ray, NO_SHAPE, 0, white, &tree)
+        expected := 0.0
+        for bounce := 0; bounce <= test.depth; bounce++ {
+            expected += 0.1*math.Pow(0.5, float64(bounce))
+        }
+        if tree.depth != test.depth || tree.culled != test.culled || math.Abs(color.X - expected) > 1e-12 {
+            t.Errorf("Expected %d bounces, %d culled and %v with depth %d and cutoff %v, got %d, %d and %v",
+                test.depth, test.culled, expected, test.maxDepth, test.minContribution, tree.depth, tree.culled, color.X)
+        }
+    }
+
+    // The depth flag wins over the scene
+    options := DefaultRenderOptions()
+    options.Width, options.Height = 2, 2
+    if renderer := NewRenderer(scene, options); renderer.options.MaxDepth != 5 {
+        t.Errorf("Expected the scene's depth, got %d", renderer.options.MaxDepth)
+    }
+    options.MaxDepth = 0
+    renderer := NewRenderer(scene, options)
+    renderer.Render()
+    if renderer.options.MaxDepth != 0 || renderer.stats.BounceDepths[0] != 4 {
+        t.Errorf("Expected 4 camera rays without bounces, got %v", renderer.stats.BounceDepths)
+    }
+
+    if _, err := interpretScene("test.txt", []string{"depth 1.5"}); err == nil {
+        t.Error("Expected an error for a fractional depth")
+    }
+}
+
+func TestObjFaces(t *testing.T) {
+    mesh, err := interpretObj("test.obj", []string{
+        "# a quad and a concave L shaped hexagon",
+        "v 0 0 0", "v 1 0 0", "v 1 1 0", "v 0 1 0",
+        "vt 0 0", "vt 1 0", "vt 1 1", "vt 0 1",
+        "vn 0 0 2",
+        "f 1/1/1 2/2/1 3/3/1 4/4/1",
+        "s off",
+        "v 0 0 1", "v 2 0 1", "v 2 1 1", "v 1 1 1", "v 1 2 1", "v 0 2 1",
+        "f -6// -5 -4 -3 -2 -1",
+        "f 1//1 2//1 3",
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(mesh.triangles) != 2 + 4 + 1 || len(mesh.normals) != 1 || mesh.normals[0].Z != 1 {
+        t.Fatalf("Expected 7 triangles and one unit normal, got %+v", mesh)
+    }
+    for _, vertex := range mesh.triangles[0] {
+        if vertex.texcoord != vertex.position || vertex.normal != 0 {
+            t.Errorf("Expected the quad's vertices to use all three indices, got %+v", vertex)
+        }
+    }
+    // Each triangle of the concave face lies inside it
+    area := 0.0
+    for i := 2; i < 6; i++ {
+        a, b, c := mesh.corners(i)
+        normal := b.VectorSub(a).CrossProduct(c.VectorSub(a))
+        centroid := a.VectorAdd(b).VectorAdd(c).VectorDiv(3*SCALE_FACTOR)
+        if normal.Z <= 0 || (centroid.X > 1 && centroid.Y > 1) || mesh.triangles[i][0].normal != -1 {
+            t.Errorf("Expected a flat triangle inside the L facing up, got %+v", mesh.triangles[i])
+        }
+        area += normal.Z/(2*SCALE_FACTOR*SCALE_FACTOR)
+    }
+    if math.Abs(area - 3) > 1e-9 {
+        t.Errorf("Expected the L's triangles to cover an area of 3, got %v", area)
+    }
+
+    mesh.transform, mesh.material = identityTransform(), &Material{}
+    scene := newScene()
+    scene.addMesh(mesh)
+    quad := scene.shapes[0].(Triangle)
+    if len(scene.shapes) != 7 || quad.mesh != mesh || scene.shapes[6].(Triangle).index != 6 {
+        t.Error("Expected a shape for each triangle of the mesh")
+    }
+    // Texture coordinates follow the vertices, not the barycentric weights
+    ray := Ray{start: raytracer.Vector{X:7.5, Y:2.5, Z:10}, direction: raytracer.Vector{X:0, Y:0, Z:-1}}
+    hit, ok := quad.Intersect(ray, 0, math.MaxFloat64)
+    if !ok || math.Abs(hit.u - 0.75) > 1e-12 || math.Abs(hit.v - 0.25) > 1e-12 || hit.shadingNormal.Z != 1 {
+        t.Errorf("Expected uv (0.75, 0.25) facing up, got %+v", hit)
+    }
+
+    for _, line := range []string{"f 1 2 9", "f 1/1/1/1 2 3", "f 1 2", "vn 0 x 1", "f 0 1 2"} {
+        if _, err := interpretObj("test.obj", []string{"v 0 0 0", "v 1 0 0", "v 0 1 0", line}); err == nil {
+            t.Errorf("Expected an error for %q", line)
+        }
+    }
+}
+
+func TestObjSmoothNormals(t *testing.T) {
+    // Two faces folded along a shared edge, whose vertices are repeated
+    mesh, err := interpretObj("test.obj", []string{
+        "v 0 0 0", "v 0 1 0", "v -1 0 1", "v 0 0 0", "v 0 1 0", "v 1 0 1",
+        "f 1 2 3", "f 5 4 6",
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    first, second := mesh.triangles[0][0].normal, mesh.triangles[1][1].normal
+    if len(mesh.normals) != 4 || first != second || math.Abs(mesh.normals[first].X) > 1e-12 || math.Abs(mesh.normals[first].Z - 1) > 1e-12 {
+        t.Errorf("Expected the repeated vertices to share a normal facing +z, got %+v", mesh.normals)
+    }
+}
+
+func TestLargeMesh(t *testing.T) {
+    // A 100 by 100 grid of quads with 10201 vertices
+    lines := []string{}
+    for y := 0; y <= 100; y++ {
+        for x := 0; x <= 100; x++ {
+            lines = append(lines, fmt.Sprintf("v %d %d 0", x, y))
+        }
+    }
+    for y := 0; y < 100; y++ {
+        for x := 0; x < 100; x++ {
+            corner := y*101 + x + 1
+            lines = append(lines, fmt.Sprintf("f %d %d %d %d", corner, corner + 1, corner + 102, corner + 101))
+        }
+    }
+    mesh, err := interpretObj("grid.obj", lines)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(mesh.positions) != 10201 || len(mesh.triangles) != 20000 {
+        t.Errorf("Expected 10201 vertices and 20000 triangles, got %d and %d", len(mesh.positions), len(mesh.triangles))
+    }
+    // The triangles share the vertex buffers instead of copying corners
+    if size := mesh.memoryUsage(); size < 20000*36 || size > 4<<20 {
+        t.Errorf("Expected a few MiB for the mesh, got %d bytes", size)
+    }
+}
+
+func TestObjMaterials(t *testing.T) {
+    directory := writeFixtures(t, map[string]string{
+        "library.mtl": "newmtl painted\nKa 0.1\nKd 0.5 0.5 0.5\nKs 1 1 1\nNs 20\nmap_Kd -s 1 1 1 textures/stripes.png\n" +
+            "newmtl glass\nKd 0 0 0\nd 0.25\nNi 1.5\n",
+        "shape.obj": "mtllib library.mtl\nv 0 0 0\nv 1 0 0\nv 0 1 0\nvt 0.25 0\nvt 0.75 0\nvt 0.25 1\n" +
+            "f 1 2 3\nusemtl painted\nf 1/1 2/2 3/3\nusemtl glass\nf 1 2 3\nusemtl missing\nf 1 2 3\nusemtl painted\nf 3 2 1\n",
+        "materials/far.mtl": "newmtl far\nKd 1 1 1\nmap_Kd textures/stripes.png\n",
+        "elsewhere/far.obj": "mtllib materials/far.mtl\nv 0 0 0\nv 1 0 0\nv 0 1 0\nusemtl far\nf 1 2 3\n",
+    })
+    if err := os.MkdirAll(filepath.Join(directory, "textures"), 0755); err != nil {
+        t.Fatal(err)
+    }
+    texture := newFramebuffer(2, 1)
+    texture.set(0, 0, raytracer.Vector{X:1, Y:0, Z:0})
+    texture.set(1, 0, raytracer.Vector{X:0, Y:0, Z:1})
+    if err := SaveImage(texture, filepath.Join(directory, "textures", "stripes.png"), DefaultOutputOptions()); err != nil {
+        t.Fatal(err)
+    }
+
+    fallback := &Material{}
+    scene := newScene()
+    if err := scene.loadMesh(filepath.Join(directory, "shape.obj"), ".obj", identityTransform(), fallback); err != nil {
+        t.Fatal(err)
+    }
+    mesh := scene.meshes[0]
+    painted, glass := mesh.materialAt(1), mesh.materialAt(2)
+    if mesh.materialAt(0) != fallback || mesh.materialAt(3) != fallback || mesh.materialAt(4) != painted || len(mesh.materials) != 2 {
+        t.Errorf("Expected the fallback material before usemtl and for unknown names, got %+v", mesh.materials)
+    }
+    if painted.ambient.Z != 0.1 || painted.diffuse.X != 0.5 || painted.shininess != 20 || painted.isTransparent() {
+        t.Errorf("Unexpected painted material %+v", painted)
+    }
+    if glass.transmission != (raytracer.Vector{X:0.75, Y:0.75, Z:0.75}) || glass.ior != 1.5 {
+        t.Errorf("Expected a transparent glass material, got %+v", glass)
+    }
+    if red, blue := painted.diffuseTexture.sample(0.25, 0.5), painted.diffuseTexture.sample(0.75, 0.5); red.X != 1 || red.Z != 0 || blue.Z != 1 {
+        t.Errorf("Expected red then blue texels, got %+v and %+v", red, blue)
+    }
+
+    // Libraries and textures not next to the file are found in the asset directories
+    far, err := parseObj(filepath.Join(directory, "elsewhere", "far.obj"), filepath.Join(directory, "missing"), directory)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(far.materials) != 1 || far.materials[0].diffuseTexture == nil || len(far.warnings) != 0 {
+        t.Errorf("Expected a textured material from the asset directory, got %+v and %q", far.materials, far.warnings)
+    }
+
+    for _, contents := range []string{"Kd 1 1 1", "newmtl a\nKd 1 x 1"} {
+        if _, _, err := interpretMtl(filepath.Join(directory, "bad.mtl"), strings.Split(contents, "\n")); err == nil {
+            t.Errorf("Expected an error for %q", contents)
+        }
+    }
+
+    // Missing libraries warn and leave the scene's material, missing
+    // textures leave their material untextured
+    mesh, err = interpretObj(filepath.Join(directory, "missing.obj"), []string{"mtllib missing.mtl", "v 0 0 0", "v 1 0 0", "v 0 1 0", "usemtl painted", "f 1 2 3"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(mesh.materials) != 0 || len(mesh.warnings) != 1 || !strings.Contains(mesh.warnings[0], "missing.mtl") {
+        t.Errorf("Expected a warning about the library and no materials, got %+v and %q", mesh.materials, mesh.warnings)
+    }
+    materials, warnings, err := interpretMtl(filepath.Join(directory, "bad.mtl"), []string{"newmtl a", "map_Kd missing.png", "newmtl b", "Kd 1 1 1"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if a := materials["a"]; a == nil || a.diffuseTexture != nil || materials["b"] == nil || len(warnings) != 1 || !strings.Contains(warnings[0], "missing.png") {
+        t.Errorf("Expected the material kept untextured with a warning, got %+v and %q", materials, warnings)
+    }
+}
+
+func TestAssetPaths(t *testing.T) {
+    directory := writeFixtures(t, map[string]string{
+        "scenes/scene.txt": "mat 0 0 0 1 1 1 0 0 0 1 0 0 0\nobj near.obj\nobj shared/far.obj\n",
+        "scenes/near.obj": "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 3\n",
+        "assets/shared/far.obj": "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 3\nf 3 2 1\n",
+    })
+
+    sceneFile := filepath.Join(directory, "scenes", "scene.txt")
+    scene, err := ParseScene(sceneFile, filepath.Join(directory, "missing"), filepath.Join(directory, "assets"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(scene.shapes) != 3 {
+        t.Errorf("Expected the triangles of both meshes, got %d shapes", len(scene.shapes))
+    }
+
+    _, err = ParseScene(sceneFile)
+    sceneErr, ok := err.(*SceneError)
+    if !ok || sceneErr.filename != sceneFile || sceneErr.line != 3 || sceneErr.column != 5 {
+        t.Errorf("Expected an error at the missing file's name, got %v", err)
+    }
+}
+
+func TestIncludesAndNamedMaterials(t *testing.T) {
+    directory := writeFixtures(t, map[string]string{
+        "lib/materials.txt": "defmat red 0.1 0 0 1 0 0 0 0 0 1 0 0 0\ndefmat glass 0 0 0 0 0 0 0 0 0 1 0 0 0 0.9 0.9 0.9 1.5\n",
+        "part.txt": "usemat red\nsph 0 0 0 1\n",
+        "scene.txt": "matlib materials.txt\ninclude part.txt\nxft 3 0 0\ninclude part.txt\nusemat glass\nsph 0 5 0 1\n",
+        "cycle.txt": "include loop.txt\n",
+        "loop.txt": "sph 0 0 0 1\ninclude cycle.txt\n",
+        "bad.txt": "sph 0 0 0 1\nsph 0 0 x 1\n",
+        "outer.txt": "include middle.txt\n",
+        "middle.txt": "\ninclude bad.txt\n",
+        "library.txt": "matlib geometry.txt\n",
+        "geometry.txt": "sph 0 0 0 1\n",
+        "twice.txt": "defmat red 0 0 0 0 0 0 0 0 0 1 0 0 0\ndefmat red 0 0 0 0 0 0 0 0 0 1 0 0 0\n",
+        "meshes.txt": "include sub/piece.txt\n",
+        "sub/piece.txt": "obj square.obj\n",
+        "sub/square.obj": "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 3\n",
+    })
+    path := func(name string) string {
+        return filepath.Join(directory, name)
+    }
+
+    // The material library is found on the asset path
+    scene, err := ParseScene(path("scene.txt"), path("lib"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(scene.shapes) != 3 {
+        t.Fatalf("Expected a sphere from each include and one more, got %d shapes", len(scene.shapes))
+    }
+    first, second, third := scene.shapes[0].(Sphere), scene.shapes[1].(Sphere), scene.shapes[2].(Sphere)
+    if first.material != second.material || first.material.diffuse.X != 1 || third.material.ior != 1.5 {
+        t.Errorf("Expected both included spheres to share red and the last to be glass, got %+v, %+v and %+v", first.material, second.material, third.material)
+    }
+    if first.transform == second.transform {
+        t.Errorf("Expected the second include to be moved by the transformation before it")
+    }
+
+    _, err = ParseScene(path("cycle.txt"))
+    if err == nil || !strings.Contains(err.Error(), "include cycle: " + path("cycle.txt") + " -> " + path("loop.txt") + " -> " + path("cycle.txt")) {
+        t.Errorf("Expected an include cycle error, got %v", err)
+    }
+    _, err = ParseScene(path("outer.txt"))
+    expected := path("bad.txt") + ":2:9: expected number, got \"x\", included from " + path("middle.txt") + ":2:1, included from " + path("outer.txt") + ":1:1"
+    if err == nil || err.Error() != expected {
+        t.Errorf("Expected the error with its include chain\n%s\ngot\n%v", expected, err)
+    }
+    for _, name := range []string{"library.txt", "twice.txt"} {
+        if _, err := ParseScene(path(name)); err == nil {
+            t.Errorf("Expected an error for %s", name)
+        }
+    }
+
+    // Converting inlines the includes and keeps the material names
+    jsonFile := path("scene.json")
+    if err := ConvertScene(path("scene.txt"), jsonFile); err == nil {
+        t.Error("Expected the material library not to be found without the asset path")
+    }
+    if err := ConvertScene(path("scene.txt"), jsonFile, path("lib")); err != nil {
+        t.Fatal(err)
+    }
+    converted, err := readJSONScene(jsonFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(converted.Objects) != 3 || converted.Objects[1].Material != "red" || converted.Objects[2].Material != "glass" || len(converted.Materials) != 2 {
+        t.Errorf("Expected three objects using the named materials, got %+v", converted)
+    }
+    fromJSON, err := ParseScene(jsonFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    scene.bvh, fromJSON.bvh = nil, nil
+    if !reflect.DeepEqual(scene, fromJSON) {
+        t.Errorf("Expected the converted scene to match")
+    }
+
+    // Meshes named in included files are found from wherever the JSON is
+    jsonFile = path("out/meshes.json")
+    if err := os.MkdirAll(path("out"), 0755); err != nil {
+        t.Fatal(err)
+    }
+    if err := ConvertScene(path("meshes.txt"), jsonFile); err != nil {
+        t.Fatal(err)
+    }
+    if converted, err = readJSONScene(jsonFile); err != nil {
+        t.Fatal(err)
+    }
+    if expected := filepath.Join("..", "sub", "square.obj"); len(converted.Objects) != 1 || converted.Objects[0].File != expected {
+        t.Errorf("Expected the mesh path %s, got %+v", expected, converted.Objects)
+    }
+    if _, err := ParseScene(jsonFile); err != nil {
+        t.Error(err)
+    }
+}
@@ -0,0 +1,381 @@
+package tracer
+
+import (
+    "fmt"
+    "image"
+    "image/color"
+    "math"
+    "runtime"
+    "sync/atomic"
+    "../vector"
+)
+
+const (
+    DEFAULT_IMAGE_WIDTH = 1000
+    DEFAULT_MAX_DEPTH = 3
+    DEFAULT_MIN_CONTRIBUTION = 1e-3
+)
+
+// RenderOptions controls how a Renderer traces a Scene. Start from
+// DefaultRenderOptions.
+type RenderOptions struct {
+    // Zero takes the size from the scene's res command, or derives it from
+    // the other dimension and the image plane's aspect ratio
+    Width int
+    Height int
+    // Zero values take the scene's samples command, then the defaults
+    Samples int
+    SamplePattern string
+    PixelFilter string
+    // Adaptive anti-aliasing replaces uniform supersampling
+    Adaptive bool
+    AdaptiveThreshold float64
+    AdaptiveDepth int
+    // Most reflection and refraction bounces, negative takes the scene's
+    // depth command, then the default
+    MaxDepth int
+    MinContribution float64
+    Workers int
+    TileSize int
+    // Shades with point lights' positions as directions and no falloff, the
+    // way the renderer did before ltp lit from the light's position
+    LegacyPointLights bool
+    // Called from the rendering goroutine after each tile of a stage,
+    // "Rendered" or "Refined", when not nil
+    Progress func(stage string, done int, total int)
+}
+
+// Renderer traces a parsed Scene into an image.
+type Renderer struct {
+    scene *Scene
+    options RenderOptions
+    // Ranges of u and v on the image plane covered by the image
+    uMin float64
+    uMax float64
+    vMin float64
+    vMax float64
+    pattern SamplePattern
+    filter PixelFilter
+    integrator *Integrator
+    stats RenderStats
+}
+
+// RenderStats holds counters gathered by each tile and added up across the
+// workers
+type RenderStats struct {
+    // Pixels adaptive anti-aliasing refined and the samples it added
+    RefinedPixels int64
+    ExtraSamples int64
+    // Camera rays by the most bounces taken by the rays they spawned
+    BounceDepths []int64
+    // Bounces not traced for adding too little to their pixel
+    CulledRays int64
+}
+
+func newRenderStats(maxDepth int) RenderStats {
+    return RenderStats{BounceDepths: make([]int64, maxDepth + 1)}
+}
+
+func (stats *RenderStats) add(other *RenderStats) {
+    atomic.AddInt64(&stats.RefinedPixels, other.RefinedPixels)
+    atomic.AddInt64(&stats.ExtraSamples, other.ExtraSamples)
+    for depth, count := range other.BounceDepths {
+        atomic.AddInt64(&stats.BounceDepths[depth], count)
+    }
+    atomic.AddInt64(&stats.CulledRays, other.CulledRays)
+}
+
+func (stats *RenderStats) addRayTree(tree *rayTree) {
+    stats.BounceDepths[tree.depth]++
+    stats.CulledRays += tree.culled
+}
+
+func DefaultRenderOptions() RenderOptions {
+    return RenderOptions{
+        Width: 0,
+        Height: 0,
+        Samples: 0,
+        SamplePattern: "",
+        PixelFilter: "",
+        Adaptive: false,
+        AdaptiveThreshold: 0.1,
+        AdaptiveDepth: 2,
+        MaxDepth: -1,
+        MinContribution: DEFAULT_MIN_CONTRIBUTION,
+        Workers: runtime.NumCPU(),
+        TileSize: 32,
+    }
+}
+
+// Checks the options a user can get wrong
+func (options RenderOptions) Validate() error {
+    if options.Width < 0 || options.Height < 0 {
+        return fmt.Errorf("width and height must not be negative")
+    }
+    if options.Samples < 0 {
+        return fmt.Errorf("samples per pixel must not be negative")
+    }
+    if options.AdaptiveDepth < 1 {
+        return fmt.Errorf("adaptive depth must be at least 1")
+    }
+    if options.MinContribution < 0 {
+        return fmt.Errorf("contribution cutoff must not be negative")
+    }
+    if _, ok := samplePatterns[options.SamplePattern]; options.SamplePattern != "" && !ok {
+        return fmt.Errorf("unknown sample pattern %q", options.SamplePattern)
+    }
+    if _, ok := pixelFilters[options.PixelFilter]; options.PixelFilter != "" && !ok {
+        return fmt.Errorf("unknown pixel filter %q", options.PixelFilter)
+    }
+    return nil
+}
+
+// The renderer fills in the options the scene or the defaults decide. The
+// scene is shaded with the options' point light model from then on.
+func NewRenderer(scene *Scene, options RenderOptions) *Renderer {
+    scene.legacyPointLights = options.LegacyPointLights
+    options.Width, options.Height = scene.imageSize(options.Width, options.Height)
+    options.Samples = firstNonZero(options.Samples, scene.samples, 1)
+    if options.Adaptive {
+        options.Samples = 1
+    }
+    options.SamplePattern = firstNonEmpty(options.SamplePattern, scene.samplePattern, DEFAULT_SAMPLE_PATTERN)
+    options.PixelFilter = firstNonEmpty(options.PixelFilter, scene.pixelFilter, DEFAULT_PIXEL_FILTER)
+    options.MaxDepth = firstNonNegative(options.MaxDepth, scene.maxDepth, DEFAULT_MAX_DEPTH)
+    renderer := &Renderer{scene: scene, options: options, uMin: 0, uMax: 1, vMin: 0, vMax: 1}
+    renderer.integrator = newIntegrator(scene, options.MaxDepth, options.MinContribution)
+    renderer.pattern = samplePatterns[options.SamplePattern]
+    renderer.filter = pixelFilters[options.PixelFilter]
+
+    // Crop the image plane to the image's aspect ratio so pixels stay square
+    imageAspect := float64(options.Width)/float64(options.Height)
+    planeAspect := scene.imagePlaneAspect()
+    if imageAspect > planeAspect {
+        span := planeAspect/imageAspect
+        renderer.vMin, renderer.vMax = 0.5 - span/2, 0.5 + span/2
+    } else if imageAspect < planeAspect {
+        span := imageAspect/planeAspect
+        renderer.uMin, renderer.uMax = 0.5 - span/2, 0.5 + span/2
+    }
+    return renderer
+}
+
+func firstNonZero(values ...int) int {
+    for _, value := range values {
+        if value != 0 {
+            return value
+        }
+    }
+    return 0
+}
+
+func firstNonNegative(values ...int) int {
+    for _, value := range values {
+        if value >= 0 {
+            return value
+        }
+    }
+    return -1
+}
+
+func firstNonEmpty(values ...string) string {
+    for _, value := range values {
+        if value != "" {
+            return value
+        }
+    }
+    return ""
+}
+
+// Width over height of the cam image plane, 1 for a degenerate plane
+func (scene *Scene) imagePlaneAspect() float64 {
+    width := scene.lowerRight.DistanceTo(scene.lowerLeft)
+    height := scene.upperLeft.DistanceTo(scene.lowerLeft)
+    if width == 0 || height == 0 {
+        return 1
+    }
+    return width/height
+}
+
+// Fills in the image size the way RenderOptions describes
+func (scene *Scene) imageSize(width int, height int) (int, int) {
+    if width == 0 && height == 0 {
+        width, height = scene.width, scene.height
+    }
+    if width == 0 && height == 0 {
+        width = DEFAULT_IMAGE_WIDTH
+    }
+    aspect := scene.imagePlaneAspect()
+    if height == 0 {
+        height = max(1, int(math.Round(float64(width)/aspect)))
+    } else if width == 0 {
+        width = max(1, int(math.Round(float64(height)*aspect)))
+    }
+    return width, height
+}
+
+func drawPixel(canvas *image.RGBA, x float64, y float64, r float64, g float64, b float64) {
+    canvas.SetRGBA(int(x), int(y), color.RGBA {
+        R: floatToRGB(r),
+        G: floatToRGB(g),
+        B: floatToRGB(b),
+        A: 255,
+    })
+}
+
+//P = u (vLL+ (1-v)UL)+(1-u)(vLR+ (1-v)UR)
+func (scene *Scene) getP(u float64, v float64) raytracer.Vector {
+    a := scene.lowerLeft.VectorScale(v).VectorAdd(scene.upperLeft.VectorScale(1-v)).VectorScale(u)
+    b := scene.lowerRight.VectorScale(v).VectorAdd(scene.upperRight.VectorScale(1-v)).VectorScale(1-u)
+    return a.VectorAdd(b)
+}
+
+// A rectangle of pixels rendered by a single worker
+type Tile struct {
+    bounds image.Rectangle
+}
+
+func (renderer *Renderer) getTiles() []Tile {
+    tiles := []Tile{}
+    size := renderer.options.TileSize
+    for y := 0; y < renderer.options.Height; y += size {
+        for x := 0; x < renderer.options.Width; x += size {
+            bounds := image.Rect(x, y, x+size, y+size).Intersect(image.Rect(0, 0, renderer.options.Width, renderer.options.Height))
+            tiles = append(tiles, Tile{bounds: bounds})
+        }
+    }
+    return tiles
+}
+
+// Point on the image plane at continuous pixel coordinates, so the center of
+// pixel (x, y) is at (x + 0.5, y + 0.5). u runs from the right edge of the
+// image plane to the left, v from top to bottom.
+func (renderer *Renderer) getPixelPoint(x float64, y float64) raytracer.Vector {
+    width := float64(renderer.options.Width)
+    height := float64(renderer.options.Height)
+    u := (width - x)/width
+    v := y/height
+    u = renderer.uMin + u*(renderer.uMax - renderer.uMin)
+    v = renderer.vMin + v*(renderer.vMax - renderer.vMin)
+    return renderer.scene.getP(u, v)
+}
+
+// Color seen through a point given in continuous pixel coordinates
+func (renderer *Renderer) trace(x float64, y float64, stats *RenderStats) raytracer.Vector {
+    ray := computeRay(renderer.scene.eye, renderer.getPixelPoint(x, y))
+    tree := rayTree{}
+    color, _ := renderer.integrator.radiance(ray, NO_SHAPE, 0, raytracer.Vector{X:1, Y:1, Z:1}, &tree)
+    stats.addRayTree(&tree)
+    return color
+}
+
+// Each tile only writes its own pixels, so workers can share the framebuffer.
+// With one sample per pixel it is taken at the pixel center. Otherwise each
+// pixel's samples land inside it and are splatted onto every tile pixel whose
+// filter covers them, so pixels in a margin around the tile are traced again
+// by each neighboring tile.
+func (renderer *Renderer) renderTile(framebuffer *Framebuffer, tile Tile) {
+    bounds := tile.bounds
+    stats := newRenderStats(renderer.options.MaxDepth)
+    defer renderer.stats.add(&stats)
+    if renderer.options.Samples == 1 {
+        for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+            for x := bounds.Min.X; x < bounds.Max.X; x++ {
+                framebuffer.set(x, y, renderer.trace(float64(x) + 0.5, float64(y) + 0.5, &stats))
+            }
+        }
+        return
+    }
+
+    radius := renderer.filter.radius
+    margin := int(math.Ceil(radius - 0.5))
+    colors := make([]raytracer.Vector, bounds.Dx()*bounds.Dy())
+    weights := make([]float64, len(colors))
+    samples := make([][2]float64, renderer.options.Samples)
+    sampled := bounds.Inset(-margin).Intersect(image.Rect(0, 0, renderer.options.Width, renderer.options.Height))
+    for pixelY := sampled.Min.Y; pixelY < sampled.Max.Y; pixelY++ {
+        for pixelX := sampled.Min.X; pixelX < sampled.Max.X; pixelX++ {
+            renderer.pattern(samples, newSampleRandom(pixelX, pixelY))
+            for _, sample := range samples {
+                sampleX, sampleY := float64(pixelX) + sample[0], float64(pixelY) + sample[1]
+                color := renderer.trace(sampleX, sampleY, &stats)
+                minX := max(bounds.Min.X, int(math.Floor(sampleX - radius - 0.5)))
+                maxX := min(bounds.Max.X - 1, int(math.Ceil(sampleX + radius - 0.5)))
+                minY := max(bounds.Min.Y, int(math.Floor(sampleY - radius - 0.5)))
+                maxY := min(bounds.Max.Y - 1, int(math.Ceil(sampleY + radius - 0.5)))
+                for y := minY; y <= maxY; y++ {
+                    for x := minX; x <= maxX; x++ {
+                        offsetX, offsetY := sampleX - float64(x) - 0.5, sampleY - float64(y) - 0.5
+                        if math.Abs(offsetX) >= radius || math.Abs(offsetY) >= radius {
+                            continue
+                        }
+                        weight := renderer.filter.weight(offsetX, offsetY)
+                        i := (y - bounds.Min.Y)*bounds.Dx() + x - bounds.Min.X
+                        colors[i] = colors[i].VectorAdd(color.VectorScale(weight))
+                        weights[i] += weight
+                    }
+                }
+            }
+        }
+    }
+    for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+        for x := bounds.Min.X; x < bounds.Max.X; x++ {
+            i := (y - bounds.Min.Y)*bounds.Dx() + x - bounds.Min.X
+            // Negative filter lobes can in theory cancel out, leave those black
+            if weights[i] > 0 {
+                framebuffer.set(x, y, colors[i].VectorDiv(weights[i]))
+            }
+        }
+    }
+}
+
+func (renderer *Renderer) tileWorker(renderTile func(Tile), tileChannel chan Tile, doneChannel chan bool) {
+    for tile := range tileChannel {
+        renderTile(tile)
+        doneChannel <- true
+    }
+}
+
+// Runs renderTile on every tile across the workers, reporting progress
+func (renderer *Renderer) forEachTile(stage string, renderTile func(Tile)) {
+    tiles := renderer.getTiles()
+    workers := int(math.Max(1, float64(renderer.options.Workers)))
+
+    tileChannel := make(chan Tile, len(tiles))
+    doneChannel := make(chan bool)
+    for _, tile := range tiles {
+        tileChannel <- tile
+    }
+    close(tileChannel)
+    for i := 0; i < workers; i++ {
+        go renderer.tileWorker(renderTile, tileChannel, doneChannel)
+    }
+
+    for finished := 1; finished <= len(tiles); finished++ {
+        <- doneChannel
+        if renderer.options.Progress != nil {
+            renderer.options.Progress(stage, finished, len(tiles))
+        }
+    }
+}
+
+// Traces the whole image. Statistics of the render are kept in Stats.
+func (renderer *Renderer) Render() *Framebuffer {
+    renderer.stats = newRenderStats(renderer.options.MaxDepth)
+    framebuffer := newFramebuffer(renderer.options.Width, renderer.options.Height)
+    renderer.forEachTile("Rendered", func(tile Tile) {
+        renderer.renderTile(framebuffer, tile)
+    })
+    if renderer.options.Adaptive {
+        initial := framebuffer.copy()
+        renderer.forEachTile("Refined", func(tile Tile) {
+            renderer.refineTile(initial, framebuffer, tile)
+        })
+    }
+    return framebuffer
+}
+
+// Counters of the latest Render
+func (renderer *Renderer) Stats() RenderStats {
+    return renderer.stats
+}
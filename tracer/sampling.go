@@ -0,0 +1,128 @@
+package tracer
+
+import (
+    "math"
+)
+
+const (
+    DEFAULT_SAMPLE_PATTERN = "jittered"
+    DEFAULT_PIXEL_FILTER = "box"
+)
+
+// Fills samples with points in the unit square
+type SamplePattern func(samples [][2]float64, random *sampleRandom)
+
+// Reconstruction filter centered on the pixel, zero beyond radius pixels
+type PixelFilter struct {
+    radius float64
+    weight func(x float64, y float64) float64
+}
+
+var samplePatterns = map[string]SamplePattern{
+    "grid": gridSample,
+    "jittered": jitteredSample,
+    "halton": haltonSample,
+}
+
+var pixelFilters = map[string]PixelFilter{
+    "box": {radius: 0.5, weight: func(x float64, y float64) float64 {
+        return 1
+    }},
+    "tent": {radius: 1, weight: func(x float64, y float64) float64 {
+        return tent(x)*tent(y)
+    }},
+    "gaussian": {radius: 1.5, weight: func(x float64, y float64) float64 {
+        return gaussian(x, 1.5)*gaussian(y, 1.5)
+    }},
+    "mitchell": {radius: 2, weight: func(x float64, y float64) float64 {
+        return mitchell(x)*mitchell(y)
+    }},
+}
+
+// splitmix64, seeded per pixel so images do not depend on the worker count
+type sampleRandom struct {
+    state uint64
+}
+
+func newSampleRandom(x int, y int) *sampleRandom {
+    return &sampleRandom{state: uint64(y)<<32 ^ uint64(x)}
+}
+
+func (random *sampleRandom) next() float64 {
+    random.state += 0x9e3779b97f4a7c15
+    z := random.state
+    z = (z ^ (z >> 30))*0xbf58476d1ce4e5b9
+    z = (z ^ (z >> 27))*0x94d049bb133111eb
+    z ^= z >> 31
+    return float64(z >> 11)/(1 << 53)
+}
+
+// Columns and rows of the grid closest to square with exactly count cells,
+// so every row is full and the samples are centered on the pixel. Prime
+// counts get a single row.
+func gridSize(count int) (int, int) {
+    rows := int(math.Sqrt(float64(count)))
+    for rows > 1 && count%rows != 0 {
+        rows--
+    }
+    rows = max(rows, 1)
+    return count/rows, rows
+}
+
+func gridSample(samples [][2]float64, random *sampleRandom) {
+    columns, rows := gridSize(len(samples))
+    for i := range samples {
+        samples[i] = [2]float64{(float64(i%columns) + 0.5)/float64(columns), (float64(i/columns) + 0.5)/float64(rows)}
+    }
+}
+
+// One random sample in each grid cell
+func jitteredSample(samples [][2]float64, random *sampleRandom) {
+    columns, rows := gridSize(len(samples))
+    for i := range samples {
+        samples[i] = [2]float64{(float64(i%columns) + random.next())/float64(columns), (float64(i/columns) + random.next())/float64(rows)}
+    }
+}
+
+// Halton points in bases 2 and 3, shifted by a random offset per pixel so
+// neighboring pixels do not share a pattern
+func haltonSample(samples [][2]float64, random *sampleRandom) {
+    shiftX, shiftY := random.next(), random.next()
+    for i := range samples {
+        x := radicalInverse(i + 1, 2) + shiftX
+        y := radicalInverse(i + 1, 3) + shiftY
+        samples[i] = [2]float64{x - math.Floor(x), y - math.Floor(y)}
+    }
+}
+
+func radicalInverse(index int, base int) float64 {
+    inverse := 0.0
+    fraction := 1/float64(base)
+    for ; index > 0; index /= base {
+        inverse += float64(index%base)*fraction
+        fraction /= float64(base)
+    }
+    return inverse
+}
+
+func tent(x float64) float64 {
+    return math.Max(0, 1 - math.Abs(x))
+}
+
+// Truncated at the radius so the weight falls to zero there
+func gaussian(x float64, radius float64) float64 {
+    const alpha = 2
+    return math.Max(0, math.Exp(-alpha*x*x) - math.Exp(-alpha*radius*radius))
+}
+
+// Mitchell-Netravali with B = C = 1/3, defined over [-2, 2]
+func mitchell(x float64) float64 {
+    const b, c = 1.0/3, 1.0/3
+    x = math.Abs(x)
+    if x < 1 {
+        return ((12 - 9*b - 6*c)*x*x*x + (-18 + 12*b + 6*c)*x*x + (6 - 2*b))/6
+    } else if x < 2 {
+        return ((-b - 6*c)*x*x*x + (6*b + 30*c)*x*x + (-12*b - 48*c)*x + (8*b + 24*c))/6
+    }
+    return 0
+}
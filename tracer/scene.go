@@ -0,0 +1,544 @@
+package tracer
+
+import (
+    "bufio"
+    "fmt"
+    "math"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "../vector"
+)
+
+// Scene holds everything parsed from a scene file: the camera, the lights
+// and the shapes with their materials and transformations.
+type Scene struct {
+    eye raytracer.Vector
+
+    lowerLeft raytracer.Vector
+    lowerRight raytracer.Vector
+    upperLeft raytracer.Vector
+    upperRight raytracer.Vector
+    // Image size from the res command, zero when unset
+    width int
+    height int
+    // Anti-aliasing from the samples command, zero values when unset
+    samples int
+    samplePattern string
+    pixelFilter string
+    // Most reflection and refraction bounces from the depth command, -1
+    // when unset
+    maxDepth int
+
+    pointLights []PointLight
+    directionalLights []DirectionalLight
+    ambientLight raytracer.Vector
+    // Shades with point lights' positions as directions and no falloff, the
+    // way old renders did. Set from RenderOptions by NewRenderer.
+    legacyPointLights bool
+
+    // In file order, each shape's ID is its index
+    shapes []Shape
+    // Meshes loaded from files, whose triangles are among the shapes
+    meshes []*Mesh
+
+    bvh *BVH
+}
+
+func newScene() *Scene {
+    return &Scene{
+        eye: emptyVector(),
+        lowerLeft: emptyVector(),
+        lowerRight: emptyVector(),
+        upperLeft: emptyVector(),
+        upperRight: emptyVector(),
+        pointLights: []PointLight{},
+        directionalLights: []DirectionalLight{},
+        maxDepth: -1,
+        ambientLight: emptyVector(),
+        shapes: []Shape{},
+        meshes: []*Mesh{},
+    }
+}
+
+// Smallest and largest number of arguments each command takes
+var sceneCommands = map[string][2]int{
+    "cam": {15, 15},
+    "res": {2, 2},
+    "samples": {1, 3},
+    "depth": {1, 1},
+    "lta": {3, 3},
+    "ltp": {6, 7},
+    "ltd": {6, 6},
+    "mat": {13, 13},
+    "mtr": {4, 4},
+    "xft": {3, 3},
+    "xfs": {3, 3},
+    "xfr": {3, 3},
+    "xfz": {0, 0},
+    "obj": {1, 1},
+    "mesh": {1, 1},
+    "sph": {4, 4},
+    "tri": {9, 9},
+    "include": {1, 1},
+    "matlib": {1, 1},
+    "defmat": {14, 18},
+    "usemat": {1, 1},
+}
+
+// SceneError points at the token of a scene file that could not be parsed,
+// or at the value of a JSON scene by its path
+type SceneError struct {
+    filename string
+    line int
+    column int
+    path string
+    message string
+    // Include commands that led to the file, innermost first
+    includes []string
+}
+
+func (err *SceneError) Error() string {
+    location := fmt.Sprintf("%s:%d:%d", err.filename, err.line, err.column)
+    if err.path != "" {
+        location = fmt.Sprintf("%s: %s", err.filename, err.path)
+    }
+    message := location + ": " + err.message
+    for _, include := range err.includes {
+        message += ", included from " + include
+    }
+    return message
+}
+
+// A command and its arguments, from a line of a text scene or a value of a
+// JSON scene
+type sceneStatement struct {
+    command token
+    arguments []token
+    // Line in a text scene, or the path of the value in a JSON scene
+    line int
+    path string
+}
+
+// Error at the token in the statement's column, or at the statement's JSON
+// value
+func (statement *sceneStatement) error(filename string, column int, format string, a ...interface{}) error {
+    return &SceneError{filename: filename, line: statement.line, column: column, path: statement.path, message: fmt.Sprintf(format, a...)}
+}
+
+// The statements of a text scene, skipping blank lines and comments
+func tokenizeScene(lines []string) []sceneStatement {
+    statements := []sceneStatement{}
+    for lineIndex, line := range lines {
+        tokens := tokenize(line)
+        if len(tokens) == 0 {
+            continue
+        }
+        statements = append(statements, sceneStatement{command: tokens[0], arguments: tokens[1:], line: lineIndex + 1})
+    }
+    return statements
+}
+
+// Whether the command exists and has a number of arguments it takes
+func (statement *sceneStatement) checkArity(filename string) error {
+    command, arguments := statement.command, statement.arguments
+    expected, ok := sceneCommands[command.text]
+    if !ok {
+        return statement.error(filename, command.column, "unknown command %q", command.text)
+    }
+    if expected[0] == expected[1] && len(arguments) != expected[0] {
+        return statement.error(filename, command.column, "%s expects %d arguments, got %d", command.text, expected[0], len(arguments))
+    }
+    if len(arguments) < expected[0] || len(arguments) > expected[1] {
+        return statement.error(filename, command.column, "%s expects %d to %d arguments, got %d", command.text, expected[0], expected[1], len(arguments))
+    }
+    return nil
+}
+
+type token struct {
+    text string
+    column int
+}
+
+// Splits a line on whitespace, dropping everything after a #
+func tokenize(line string) []token {
+    tokens := []token{}
+    start := -1
+    for i, char := range line {
+        isSpace := char == ' ' || char == '\t' || char == '\r'
+        if char == '#' || isSpace {
+            if start != -1 {
+                tokens = append(tokens, token{text: line[start:i], column: start + 1})
+                start = -1
+            }
+            if char == '#' {
+                return tokens
+            }
+        } else if start == -1 {
+            start = i
+        }
+    }
+    if start != -1 {
+        tokens = append(tokens, token{text: line[start:], column: start + 1})
+    }
+    return tokens
+}
+
+func parseNumbers(tokens []token) ([]float64, *token) {
+    numbers := make([]float64, len(tokens))
+    for i, argument := range tokens {
+        number, err := strconv.ParseFloat(argument.text, 64)
+        if err != nil {
+            return nil, &tokens[i]
+        }
+        numbers[i] = number
+    }
+    return numbers, nil
+}
+
+func vectorAt(numbers []float64, index int) raytracer.Vector {
+    return raytracer.Vector{X:numbers[index], Y:numbers[index+1], Z:numbers[index+2]}
+}
+
+// The numbers after a defmat statement's name: the mat command's 13, then
+// optionally the mtr command's 4
+func (statement *sceneStatement) defmatNumbers(filename string) ([]float64, error) {
+    arguments := statement.arguments
+    if len(arguments) != 14 && len(arguments) != 18 {
+        return nil, statement.error(filename, statement.command.column, "defmat expects a name and 13 or 17 numbers, got %d numbers", len(arguments) - 1)
+    }
+    numbers, badToken := parseNumbers(arguments[1:])
+    if badToken != nil {
+        return nil, statement.error(filename, badToken.column, "expected number, got %q", badToken.text)
+    }
+    if len(numbers) == 17 && numbers[16] <= 0 {
+        return nil, statement.error(filename, arguments[17].column, "defmat index of refraction must be positive, got %q", arguments[17].text)
+    }
+    return numbers, nil
+}
+
+// Finds and reads the file an include or matlib statement names, failing
+// when it is among the files being read, outermost first
+func readIncluded(filename string, statement *sceneStatement, assetPaths []string, files []string) (string, []sceneStatement, error) {
+    argument := statement.arguments[0]
+    path, err := findAsset(filename, argument.text, assetPaths)
+    if err != nil {
+        return "", nil, statement.error(filename, argument.column, "%v", err)
+    }
+    absolute, _ := filepath.Abs(path)
+    for i, file := range files {
+        if other, _ := filepath.Abs(file); other == absolute {
+            return "", nil, statement.error(filename, argument.column, "include cycle: %s -> %s", strings.Join(files[i:], " -> "), path)
+        }
+    }
+    lines, err := readLines(path)
+    if err != nil {
+        return "", nil, statement.error(filename, argument.column, "%v", err)
+    }
+    return path, tokenizeScene(lines), nil
+}
+
+// Adds the include statement to the chain of an error in the file it
+// included
+func includedFrom(err error, filename string, statement *sceneStatement) error {
+    sceneErr, ok := err.(*SceneError)
+    if !ok {
+        return statement.error(filename, statement.arguments[0].column, "%v", err)
+    }
+    location := fmt.Sprintf("%s:%d:%d", filename, statement.line, statement.command.column)
+    if statement.path != "" {
+        location = fmt.Sprintf("%s: %s", filename, statement.path)
+    }
+    sceneErr.includes = append(sceneErr.includes, location)
+    return sceneErr
+}
+
+// Files the scene names are found relative to the scene file, then in
+// each of assetPaths
+func interpretScene(filename string, lines []string, assetPaths ...string) (*Scene, error) {
+    return interpretStatements(filename, tokenizeScene(lines), assetPaths...)
+}
+
+// Interprets statements into one scene. Included files share the current
+// material, transformation and named materials with the file including
+// them.
+type sceneInterpreter struct {
+    scene *Scene
+    assetPaths []string
+    // Shapes keep a reference to the material current when they were made
+    currentMaterial *Material
+    currentTransform Transform
+    // Defined by defmat
+    materials map[string]*Material
+    // Files being interpreted, outermost first
+    files []string
+}
+
+func interpretStatements(filename string, statements []sceneStatement, assetPaths ...string) (*Scene, error) {
+    interpreter := &sceneInterpreter{
+        scene: newScene(),
+        assetPaths: assetPaths,
+        currentMaterial: &Material{},
+        currentTransform: identityTransform(),
+        materials: map[string]*Material{},
+    }
+    if err := interpreter.run(filename, statements, false); err != nil {
+        return nil, err
+    }
+    interpreter.scene.bvh = buildBVH(interpreter.scene)
+    return interpreter.scene, nil
+}
+
+// With definitionsOnly set, as for material libraries, only defmat and
+// matlib commands are allowed
+func (interpreter *sceneInterpreter) run(filename string, statements []sceneStatement, definitionsOnly bool) error {
+    scene := interpreter.scene
+    interpreter.files = append(interpreter.files, filename)
+    defer func() {
+        interpreter.files = interpreter.files[:len(interpreter.files)-1]
+    }()
+    for i := range statements {
+        statement := &statements[i]
+        sceneError := func(column int, format string, a ...interface{}) error {
+            return statement.error(filename, column, format, a...)
+        }
+        if err := statement.checkArity(filename); err != nil {
+            return err
+        }
+        command, arguments := statement.command, statement.arguments
+        if definitionsOnly && command.text != "defmat" && command.text != "matlib" {
+            return sceneError(command.column, "a material library may only hold defmat and matlib commands, got %s", command.text)
+        }
+        ok := true
+
+        // Commands with names among their arguments
+        switch command.text {
+        case "include", "matlib":
+            path, included, err := readIncluded(filename, statement, interpreter.assetPaths, interpreter.files)
+            if err != nil {
+                return err
+            }
+            if err := interpreter.run(path, included, command.text == "matlib"); err != nil {
+                return includedFrom(err, filename, statement)
+            }
+            continue
+        case "defmat":
+            name := arguments[0]
+            if _, ok := interpreter.materials[name.text]; ok {
+                return sceneError(name.column, "material %q is already defined", name.text)
+            }
+            numbers, err := statement.defmatNumbers(filename)
+            if err != nil {
+                return err
+            }
+            material := newMaterial(numbers)
+            if len(numbers) == 17 {
+                material.transmission = vectorAt(numbers, 13)
+                material.ior = numbers[16]
+            }
+            interpreter.materials[name.text] = material
+            continue
+        case "usemat":
+            material, ok := interpreter.materials[arguments[0].text]
+            if !ok {
+                return sceneError(arguments[0].column, "unknown material %q", arguments[0].text)
+            }
+            interpreter.currentMaterial = material
+            continue
+        case "obj", "mesh":
+            path, err := findAsset(filename, arguments[0].text, interpreter.assetPaths)
+            if err != nil {
+                return sceneError(arguments[0].column, "%v", err)
+            }
+            // obj reads any file as OBJ, mesh goes by the extension
+            format := strings.ToLower(filepath.Ext(path))
+            if command.text == "obj" {
+                format = ".obj"
+            }
+            if err := scene.loadMesh(path, format, interpreter.currentTransform, interpreter.currentMaterial, interpreter.assetPaths...); err != nil {
+                // Errors inside the OBJ file point there already
+                if _, ok := err.(*SceneError); ok {
+                    return err
+                }
+                return sceneError(arguments[0].column, "%v", err)
+            }
+            continue
+        case "samples":
+            count, err := strconv.Atoi(arguments[0].text)
+            if err != nil || count < 1 {
+                return sceneError(arguments[0].column, "samples expects a positive integer, got %q", arguments[0].text)
+            }
+            scene.samples = count
+            if len(arguments) > 1 {
+                if _, ok := samplePatterns[arguments[1].text]; !ok {
+                    return sceneError(arguments[1].column, "unknown sample pattern %q, expected grid, jittered or halton", arguments[1].text)
+                }
+                scene.samplePattern = arguments[1].text
+            }
+            if len(arguments) > 2 {
+                if _, ok := pixelFilters[arguments[2].text]; !ok {
+                    return sceneError(arguments[2].column, "unknown pixel filter %q, expected box, tent, gaussian or mitchell", arguments[2].text)
+                }
+                scene.pixelFilter = arguments[2].text
+            }
+            continue
+        }
+        numbers, badToken := parseNumbers(arguments)
+        if badToken != nil {
+            return sceneError(badToken.column, "expected number, got %q", badToken.text)
+        }
+
+        switch command.text {
+        case "cam":
+            scene.eye = vectorAt(numbers, 0).VectorScale(SCALE_FACTOR)
+            scene.lowerLeft = vectorAt(numbers, 3).VectorScale(SCALE_FACTOR)
+            scene.lowerRight = vectorAt(numbers, 6).VectorScale(SCALE_FACTOR)
+            scene.upperLeft = vectorAt(numbers, 9).VectorScale(SCALE_FACTOR)
+            scene.upperRight = vectorAt(numbers, 12).VectorScale(SCALE_FACTOR)
+        case "res":
+            for i, size := range numbers {
+                if size < 1 || size != math.Trunc(size) {
+                    return sceneError(arguments[i].column, "res expects a positive integer, got %q", arguments[i].text)
+                }
+            }
+            scene.width, scene.height = int(numbers[0]), int(numbers[1])
+        case "depth":
+            if depth := numbers[0]; depth < 0 || depth != math.Trunc(depth) {
+                return sceneError(arguments[0].column, "depth expects a non-negative integer, got %q", arguments[0].text)
+            }
+            scene.maxDepth = int(numbers[0])
+        case "lta":
+            scene.ambientLight = vectorAt(numbers, 0)
+        case "ltp":
+            light := PointLight{position: vectorAt(numbers, 0).VectorScale(SCALE_FACTOR), color: vectorAt(numbers, 3)}
+            if len(numbers) == 7 {
+                if falloff := numbers[6]; falloff != 0 && falloff != 1 && falloff != 2 {
+                    return sceneError(arguments[6].column, "ltp falloff must be 0, 1 or 2, got %q", arguments[6].text)
+                }
+                light.falloff = int(numbers[6])
+            }
+            scene.pointLights = append(scene.pointLights, light)
+        case "ltd":
+            light := DirectionalLight{direction: vectorAt(numbers, 0).VectorScale(SCALE_FACTOR), color: vectorAt(numbers, 3)}
+            scene.directionalLights = append(scene.directionalLights, light)
+        case "mat":
+            interpreter.currentMaterial = newMaterial(numbers)
+        case "mtr":
+            // Makes the current material transparent, later mat commands
+            // start opaque again
+            if numbers[3] <= 0 {
+                return sceneError(arguments[3].column, "mtr index of refraction must be positive, got %q", arguments[3].text)
+            }
+            material := *interpreter.currentMaterial
+            material.transmission = vectorAt(numbers, 0)
+            material.ior = numbers[3]
+            interpreter.currentMaterial = &material
+        case "xft":
+            translation := vectorAt(numbers, 0).VectorScale(SCALE_FACTOR)
+            interpreter.currentTransform, ok = interpreter.currentTransform.compose(raytracer.Translation(translation))
+        case "xfs":
+            scale := vectorAt(numbers, 0)
+            if scale.X == 0 || scale.Y == 0 || scale.Z == 0 {
+                return sceneError(arguments[0].column, "xfs scale factors must be non-zero")
+            }
+            interpreter.currentTransform, ok = interpreter.currentTransform.compose(raytracer.Scaling(scale))
+        case "xfr":
+            interpreter.currentTransform, ok = interpreter.currentTransform.compose(raytracer.Rotation(vectorAt(numbers, 0)))
+        case "xfz":
+            interpreter.currentTransform = identityTransform()
+        case "sph":
+            sphere := Sphere{
+                id: len(scene.shapes),
+                center: vectorAt(numbers, 0).VectorScale(SCALE_FACTOR),
+                radius: numbers[3]*SCALE_FACTOR,
+                transform: interpreter.currentTransform,
+                material: interpreter.currentMaterial,
+            }
+            scene.shapes = append(scene.shapes, sphere)
+        case "tri":
+            a := vectorAt(numbers, 0).VectorScale(SCALE_FACTOR)
+            b := vectorAt(numbers, 3).VectorScale(SCALE_FACTOR)
+            c := vectorAt(numbers, 6).VectorScale(SCALE_FACTOR)
+            scene.addMesh(newTriangleMesh(a, b, c, interpreter.currentTransform, interpreter.currentMaterial))
+        }
+        if !ok {
+            return sceneError(command.column, "%s makes the transformation singular", command.text)
+        }
+    }
+    return nil
+}
+
+// The opaque material of the mat command's 13 numbers
+func newMaterial(numbers []float64) *Material {
+    return &Material{
+        ambient: vectorAt(numbers, 0),
+        diffuse: vectorAt(numbers, 3),
+        specular: vectorAt(numbers, 6),
+        shininess: numbers[9],
+        reflective: vectorAt(numbers, 10),
+    }
+}
+
+// Text scene files, JSON scenes, or .gltf and .glb files holding a whole
+// scene
+func ParseScene(filename string, assetPaths ...string) (*Scene, error) {
+    switch strings.ToLower(filepath.Ext(filename)) {
+    case ".json":
+        return parseJSONScene(filename, assetPaths...)
+    case ".gltf", ".glb":
+        return parseGltfScene(filename)
+    }
+    lines, err := readLines(filename)
+    if err != nil {
+        return nil, err
+    }
+    return interpretScene(filename, lines, assetPaths...)
+}
+
+// Paths in a file are relative to the file's directory
+func resolvePath(from string, path string) string {
+    if filepath.IsAbs(path) {
+        return path
+    }
+    return filepath.Join(filepath.Dir(from), path)
+}
+
+// Finds a file named by the file from, relative to it and then in each of
+// the asset directories
+func findAsset(from string, path string, assetPaths []string) (string, error) {
+    candidates := []string{resolvePath(from, path)}
+    if !filepath.IsAbs(path) {
+        for _, directory := range assetPaths {
+            candidates = append(candidates, filepath.Join(directory, path))
+        }
+    }
+    for _, candidate := range candidates {
+        if _, err := os.Stat(candidate); err == nil {
+            return candidate, nil
+        }
+    }
+    if len(candidates) == 1 {
+        return "", fmt.Errorf("%s not found", candidates[0])
+    }
+    return "", fmt.Errorf("%s not found in %s or the asset path %s", path, filepath.Dir(candidates[0]), strings.Join(assetPaths, string(filepath.ListSeparator)))
+}
+
+func readLines(filename string) ([]string, error) {
+    lines := []string{}
+    file, err := os.Open(filename)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        lines = append(lines, scanner.Text())
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, err
+    }
+    return lines, nil
+}
+
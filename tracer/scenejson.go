@@ -0,0 +1,549 @@
+package tracer
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// jsonScene is the structured form of a text scene. The state the text
+// commands build up is spelled out: each object names its material and
+// lists the transformations applied to it since the last xfz, first one
+// first. Objects without a material use the all zero one.
+type jsonScene struct {
+    Camera *jsonCamera `json:"camera,omitempty"`
+    Resolution *[2]float64 `json:"resolution,omitempty"`
+    Samples *jsonSamples `json:"samples,omitempty"`
+    Depth *float64 `json:"depth,omitempty"`
+    Ambient *[3]float64 `json:"ambient,omitempty"`
+    Lights []jsonLight `json:"lights,omitempty"`
+    Materials map[string]jsonMaterial `json:"materials,omitempty"`
+    Objects []jsonObject `json:"objects,omitempty"`
+}
+
+type jsonCamera struct {
+    Eye [3]float64 `json:"eye"`
+    LowerLeft [3]float64 `json:"lowerLeft"`
+    LowerRight [3]float64 `json:"lowerRight"`
+    UpperLeft [3]float64 `json:"upperLeft"`
+    UpperRight [3]float64 `json:"upperRight"`
+}
+
+type jsonSamples struct {
+    Count float64 `json:"count"`
+    Pattern string `json:"pattern,omitempty"`
+    Filter string `json:"filter,omitempty"`
+}
+
+// A "point" light with a position or a "directional" one with a direction
+type jsonLight struct {
+    Type string `json:"type"`
+    Position *[3]float64 `json:"position,omitempty"`
+    Direction *[3]float64 `json:"direction,omitempty"`
+    Color [3]float64 `json:"color"`
+    Falloff *float64 `json:"falloff,omitempty"`
+}
+
+// The mat command's values, and the mtr command's when transparent
+type jsonMaterial struct {
+    Ambient [3]float64 `json:"ambient"`
+    Diffuse [3]float64 `json:"diffuse"`
+    Specular [3]float64 `json:"specular"`
+    Shininess float64 `json:"shininess"`
+    Reflective [3]float64 `json:"reflective"`
+    Transmission *[3]float64 `json:"transmission,omitempty"`
+    IOR *float64 `json:"ior,omitempty"`
+}
+
+// Exactly one of the fields is set
+type jsonTransform struct {
+    Translate *[3]float64 `json:"translate,omitempty"`
+    Scale *[3]float64 `json:"scale,omitempty"`
+    Rotate *[3]float64 `json:"rotate,omitempty"`
+}
+
+// A "sphere", "triangle", "mesh" or "obj", the last read as OBJ whatever
+// the file's extension
+type jsonObject struct {
+    Type string `json:"type"`
+    Center *[3]float64 `json:"center,omitempty"`
+    Radius *float64 `json:"radius,omitempty"`
+    Vertices *[3][3]float64 `json:"vertices,omitempty"`
+    File string `json:"file,omitempty"`
+    Material string `json:"material,omitempty"`
+    Transform []jsonTransform `json:"transform,omitempty"`
+}
+
+func readJSONScene(filename string) (*jsonScene, error) {
+    contents, err := os.ReadFile(filename)
+    if err != nil {
+        return nil, err
+    }
+    decoder := json.NewDecoder(bytes.NewReader(contents))
+    decoder.DisallowUnknownFields()
+    scene := &jsonScene{}
+    if err := decoder.Decode(scene); err != nil {
+        return nil, fmt.Errorf("%s: %v", filename, err)
+    }
+    return scene, nil
+}
+
+func parseJSONScene(filename string, assetPaths ...string) (*Scene, error) {
+    scene, err := readJSONScene(filename)
+    if err != nil {
+        return nil, err
+    }
+    statements, err := scene.statements(filename)
+    if err != nil {
+        return nil, err
+    }
+    return interpretStatements(filename, statements, assetPaths...)
+}
+
+func formatNumber(number float64) string {
+    return strconv.FormatFloat(number, 'g', -1, 64)
+}
+
+// The text commands that build the scene, each pointing back at the JSON
+// value it comes from
+func (scene *jsonScene) statements(filename string) ([]sceneStatement, error) {
+    statements := []sceneStatement{}
+    add := func(path string, command string, arguments ...interface{}) {
+        statement := sceneStatement{command: token{text: command, column: 1}, path: path}
+        for _, argument := range arguments {
+            switch value := argument.(type) {
+            case float64:
+                statement.arguments = append(statement.arguments, token{text: formatNumber(value)})
+            case [3]float64:
+                for _, number := range value {
+                    statement.arguments = append(statement.arguments, token{text: formatNumber(number)})
+                }
+            case string:
+                statement.arguments = append(statement.arguments, token{text: value})
+            }
+        }
+        for i := range statement.arguments {
+            statement.arguments[i].column = i + 2
+        }
+        statements = append(statements, statement)
+    }
+    jsonError := func(path string, format string, a ...interface{}) error {
+        return &SceneError{filename: filename, path: path, message: fmt.Sprintf(format, a...)}
+    }
+
+    if camera := scene.Camera; camera != nil {
+        add("camera", "cam", camera.Eye, camera.LowerLeft, camera.LowerRight, camera.UpperLeft, camera.UpperRight)
+    }
+    if scene.Resolution != nil {
+        add("resolution", "res", scene.Resolution[0], scene.Resolution[1])
+    }
+    if samples := scene.Samples; samples != nil {
+        arguments := []interface{}{samples.Count}
+        if samples.Pattern != "" {
+            arguments = append(arguments, samples.Pattern)
+        }
+        if samples.Filter != "" {
+            if samples.Pattern == "" {
+                return nil, jsonError("samples", "a filter needs a pattern too")
+            }
+            arguments = append(arguments, samples.Filter)
+        }
+        add("samples", "samples", arguments...)
+    }
+    if scene.Depth != nil {
+        add("depth", "depth", *scene.Depth)
+    }
+    if scene.Ambient != nil {
+        add("ambient", "lta", *scene.Ambient)
+    }
+    for i, light := range scene.Lights {
+        path := fmt.Sprintf("lights[%d]", i)
+        switch {
+        case light.Type == "point" && light.Position != nil:
+            arguments := []interface{}{*light.Position, light.Color}
+            if light.Falloff != nil {
+                arguments = append(arguments, *light.Falloff)
+            }
+            add(path, "ltp", arguments...)
+        case light.Type == "directional" && light.Direction != nil:
+            add(path, "ltd", *light.Direction, light.Color)
+        default:
+            return nil, jsonError(path, "expected a point light with a position or a directional light with a direction")
+        }
+    }
+
+    // Every named material is defined up front and objects pick theirs
+    names := []string{}
+    for name := range scene.Materials {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        value := scene.Materials[name]
+        path := "materials." + name
+        arguments := []interface{}{name, value.Ambient, value.Diffuse, value.Specular, value.Shininess, value.Reflective}
+        if value.Transmission != nil || value.IOR != nil {
+            if value.Transmission == nil || value.IOR == nil {
+                return nil, jsonError(path, "transmission and ior go together")
+            }
+            arguments = append(arguments, *value.Transmission, *value.IOR)
+        }
+        add(path, "defmat", arguments...)
+    }
+
+    // What the commands so far leave current
+    material := ""
+    transform := []jsonTransform{}
+    for i, object := range scene.Objects {
+        path := fmt.Sprintf("objects[%d]", i)
+        if object.Material != material {
+            if object.Material == "" {
+                add(path, "mat", [3]float64{}, [3]float64{}, [3]float64{}, 0.0, [3]float64{})
+            } else {
+                add(path + ".material", "usemat", object.Material)
+            }
+            material = object.Material
+        }
+
+        // Transformations only compose, so they are reset unless the
+        // current ones start the object's
+        isPrefix := len(transform) <= len(object.Transform)
+        for j := 0; isPrefix && j < len(transform); j++ {
+            isPrefix = transform[j].equals(object.Transform[j])
+        }
+        start := len(transform)
+        if !isPrefix {
+            add(path + ".transform", "xfz")
+            start = 0
+        }
+        for j := start; j < len(object.Transform); j++ {
+            command, values, ok := object.Transform[j].command()
+            if !ok {
+                return nil, jsonError(fmt.Sprintf("%s.transform[%d]", path, j), "expected one of translate, scale or rotate")
+            }
+            add(fmt.Sprintf("%s.transform[%d]", path, j), command, values)
+        }
+        transform = object.Transform
+
+        switch {
+        case object.Type == "sphere" && object.Center != nil && object.Radius != nil:
+            add(path, "sph", *object.Center, *object.Radius)
+        case object.Type == "triangle" && object.Vertices != nil:
+            add(path, "tri", object.Vertices[0], object.Vertices[1], object.Vertices[2])
+        case (object.Type == "mesh" || object.Type == "obj") && object.File != "":
+            add(path, object.Type, object.File)
+        default:
+            return nil, jsonError(path, "expected a sphere with a center and radius, a triangle with vertices, or a mesh or obj with a file")
+        }
+    }
+    return statements, nil
+}
+
+// The command for the transformation and its values, false unless exactly
+// one is set
+func (transform jsonTransform) command() (string, [3]float64, bool) {
+    commands := []string{}
+    var values [3]float64
+    for command, field := range map[string]*[3]float64{"xft": transform.Translate, "xfs": transform.Scale, "xfr": transform.Rotate} {
+        if field != nil {
+            commands = append(commands, command)
+            values = *field
+        }
+    }
+    if len(commands) != 1 {
+        return "", values, false
+    }
+    return commands[0], values, true
+}
+
+func (transform jsonTransform) equals(other jsonTransform) bool {
+    command, values, ok := transform.command()
+    otherCommand, otherValues, otherOk := other.command()
+    return ok && otherOk && command == otherCommand && values == otherValues
+}
+
+// Builds the JSON form of text statements. Included files are inlined.
+type jsonConverter struct {
+    scene *jsonScene
+    // Nil for the all zero material
+    material *jsonMaterial
+    // Materials defined by defmat, by name and back
+    defined map[string]*jsonMaterial
+    names map[*jsonMaterial]string
+    // Materials of scene.Objects
+    objectMaterials []*jsonMaterial
+    transform []jsonTransform
+    // Files being converted, outermost first
+    files []string
+    assetPaths []string
+    // Where the JSON is written, mesh paths are made relative to it
+    directory string
+}
+
+// The JSON form of a text scene, to be written to directory. Included files
+// are searched like the renderer does, but only the syntax is checked, so
+// the meshes it names need not exist. Materials without a defmat name are
+// numbered in order of use.
+func textToJSONScene(filename string, lines []string, directory string, assetPaths ...string) (*jsonScene, error) {
+    converter := &jsonConverter{scene: &jsonScene{}, defined: map[string]*jsonMaterial{}, names: map[*jsonMaterial]string{}, assetPaths: assetPaths, directory: directory}
+    if err := converter.convert(filename, tokenizeScene(lines), false); err != nil {
+        return nil, err
+    }
+    scene := converter.scene
+    scene.Materials = map[string]jsonMaterial{}
+    for name, material := range converter.defined {
+        scene.Materials[name] = *material
+    }
+    next := 1
+    for i, material := range converter.objectMaterials {
+        if material == nil {
+            continue
+        }
+        name, ok := converter.names[material]
+        if !ok {
+            for {
+                name = fmt.Sprintf("material%d", next)
+                next++
+                if _, taken := converter.defined[name]; !taken {
+                    break
+                }
+            }
+            converter.names[material] = name
+            scene.Materials[name] = *material
+        }
+        scene.Objects[i].Material = name
+    }
+    return scene, nil
+}
+
+func (converter *jsonConverter) convert(filename string, statements []sceneStatement, definitionsOnly bool) error {
+    scene := converter.scene
+    converter.files = append(converter.files, filename)
+    defer func() {
+        converter.files = converter.files[:len(converter.files)-1]
+    }()
+    for i := range statements {
+        statement := &statements[i]
+        if err := statement.checkArity(filename); err != nil {
+            return err
+        }
+        command, arguments := statement.command.text, statement.arguments
+        if definitionsOnly && command != "defmat" && command != "matlib" {
+            return statement.error(filename, statement.command.column, "a material library may only hold defmat and matlib commands, got %s", command)
+        }
+
+        // Commands with names among their arguments
+        switch command {
+        case "include", "matlib":
+            path, included, err := readIncluded(filename, statement, converter.assetPaths, converter.files)
+            if err != nil {
+                return err
+            }
+            if err := converter.convert(path, included, command == "matlib"); err != nil {
+                return includedFrom(err, filename, statement)
+            }
+            continue
+        case "defmat":
+            name := arguments[0]
+            if _, ok := converter.defined[name.text]; ok {
+                return statement.error(filename, name.column, "material %q is already defined", name.text)
+            }
+            numbers, err := statement.defmatNumbers(filename)
+            if err != nil {
+                return err
+            }
+            vector := func(index int) [3]float64 {
+                return [3]float64{numbers[index], numbers[index + 1], numbers[index + 2]}
+            }
+            material := &jsonMaterial{Ambient: vector(0), Diffuse: vector(3), Specular: vector(6), Shininess: numbers[9], Reflective: vector(10)}
+            if len(numbers) == 17 {
+                transmission := vector(13)
+                material.Transmission, material.IOR = &transmission, &numbers[16]
+            }
+            converter.defined[name.text], converter.names[material] = material, name.text
+            continue
+        case "usemat":
+            material, ok := converter.defined[arguments[0].text]
+            if !ok {
+                return statement.error(filename, arguments[0].column, "unknown material %q", arguments[0].text)
+            }
+            converter.material = material
+            continue
+        case "samples":
+            count, err := strconv.ParseFloat(arguments[0].text, 64)
+            if err != nil {
+                return statement.error(filename, arguments[0].column, "expected number, got %q", arguments[0].text)
+            }
+            scene.Samples = &jsonSamples{Count: count}
+            if len(arguments) > 1 {
+                scene.Samples.Pattern = arguments[1].text
+            }
+            if len(arguments) > 2 {
+                scene.Samples.Filter = arguments[2].text
+            }
+            continue
+        }
+        var numbers []float64
+        if command != "obj" && command != "mesh" {
+            var badToken *token
+            if numbers, badToken = parseNumbers(arguments); badToken != nil {
+                return statement.error(filename, badToken.column, "expected number, got %q", badToken.text)
+            }
+        }
+        vector := func(index int) [3]float64 {
+            return [3]float64{numbers[index], numbers[index + 1], numbers[index + 2]}
+        }
+        object := jsonObject{}
+
+        switch command {
+        case "cam":
+            scene.Camera = &jsonCamera{Eye: vector(0), LowerLeft: vector(3), LowerRight: vector(6), UpperLeft: vector(9), UpperRight: vector(12)}
+        case "res":
+            scene.Resolution = &[2]float64{numbers[0], numbers[1]}
+        case "depth":
+            scene.Depth = &numbers[0]
+        case "lta":
+            ambient := vector(0)
+            scene.Ambient = &ambient
+        case "ltp":
+            position := vector(0)
+            light := jsonLight{Type: "point", Position: &position, Color: vector(3)}
+            if len(numbers) == 7 {
+                light.Falloff = &numbers[6]
+            }
+            scene.Lights = append(scene.Lights, light)
+        case "ltd":
+            direction := vector(0)
+            scene.Lights = append(scene.Lights, jsonLight{Type: "directional", Direction: &direction, Color: vector(3)})
+        case "mat":
+            converter.material = &jsonMaterial{Ambient: vector(0), Diffuse: vector(3), Specular: vector(6), Shininess: numbers[9], Reflective: vector(10)}
+        case "mtr":
+            // Like the command, a copy of the current material
+            transparent := jsonMaterial{}
+            if converter.material != nil {
+                transparent = *converter.material
+            }
+            transmission := vector(0)
+            transparent.Transmission, transparent.IOR = &transmission, &numbers[3]
+            converter.material = &transparent
+        case "xft", "xfs", "xfr":
+            values := vector(0)
+            step := map[string]jsonTransform{"xft": {Translate: &values}, "xfs": {Scale: &values}, "xfr": {Rotate: &values}}[command]
+            converter.transform = append(append([]jsonTransform{}, converter.transform...), step)
+        case "xfz":
+            converter.transform = []jsonTransform{}
+        case "sph":
+            center := vector(0)
+            object = jsonObject{Type: "sphere", Center: &center, Radius: &numbers[3]}
+        case "tri":
+            object = jsonObject{Type: "triangle", Vertices: &[3][3]float64{vector(0), vector(3), vector(6)}}
+        case "obj", "mesh":
+            object = jsonObject{Type: command, File: rebasePath(filename, arguments[0].text, converter.directory)}
+        }
+        if object.Type == "" {
+            continue
+        }
+        if len(converter.transform) > 0 {
+            object.Transform = converter.transform
+        }
+        scene.Objects = append(scene.Objects, object)
+        converter.objectMaterials = append(converter.objectMaterials, converter.material)
+    }
+    return nil
+}
+
+// Arrays of numbers, which are short, on one line
+var jsonNumberArray = regexp.MustCompile(`\[[^\[\]{}"]*\]`)
+
+func (scene *jsonScene) marshal() ([]byte, error) {
+    contents, err := json.MarshalIndent(scene, "", "    ")
+    if err != nil {
+        return nil, err
+    }
+    contents = jsonNumberArray.ReplaceAllFunc(contents, func(array []byte) []byte {
+        return []byte(strings.Join(strings.Fields(string(array)), " "))
+    })
+    return append(contents, '\n'), nil
+}
+
+// A path named in the file from as written in a file in directory. Paths
+// that are absolute or not next to from, which the asset path finds, stay
+// as they are.
+func rebasePath(from string, path string, directory string) string {
+    resolved := resolvePath(from, path)
+    if _, err := os.Stat(resolved); filepath.IsAbs(path) || err != nil {
+        return path
+    }
+    if relative, err := filepath.Rel(directory, resolved); err == nil {
+        return relative
+    }
+    // Only one of them is absolute
+    if absolute, err := filepath.Abs(resolved); err == nil {
+        return absolute
+    }
+    return path
+}
+
+// Writes a text scene as JSON or a .json scene as text, as the output's
+// extension, .json or .txt, asks. Includes are searched in assetPaths too,
+// and mesh paths are rewritten relative to the output.
+func ConvertScene(input string, output string, assetPaths ...string) error {
+    directory := filepath.Dir(output)
+    // Anything ParseScene does not read as JSON or glTF is a text scene
+    inputFormat := strings.ToLower(filepath.Ext(input))
+    isText := inputFormat != ".json" && inputFormat != ".gltf" && inputFormat != ".glb"
+    var contents []byte
+    switch outputFormat := strings.ToLower(filepath.Ext(output)); outputFormat {
+    case ".txt":
+        if inputFormat != ".json" {
+            return fmt.Errorf("%s: only .json scenes convert to text", input)
+        }
+        scene, err := readJSONScene(input)
+        if err != nil {
+            return err
+        }
+        statements, err := scene.statements(input)
+        if err != nil {
+            return err
+        }
+        var text strings.Builder
+        for _, statement := range statements {
+            text.WriteString(statement.command.text)
+            if command := statement.command.text; command == "obj" || command == "mesh" {
+                statement.arguments[0].text = rebasePath(input, statement.arguments[0].text, directory)
+            }
+            for _, argument := range statement.arguments {
+                if len(tokenize(argument.text)) != 1 || tokenize(argument.text)[0].text != argument.text {
+                    return statement.error(input, argument.column, "%q cannot be written in a text scene", argument.text)
+                }
+                text.WriteString(" " + argument.text)
+            }
+            text.WriteString("\n")
+        }
+        contents = []byte(text.String())
+    case ".json":
+        if !isText {
+            return fmt.Errorf("%s: only text scenes convert to JSON", input)
+        }
+        lines, err := readLines(input)
+        if err != nil {
+            return err
+        }
+        scene, err := textToJSONScene(input, lines, directory, assetPaths...)
+        if err != nil {
+            return err
+        }
+        if contents, err = scene.marshal(); err != nil {
+            return err
+        }
+    default:
+        return fmt.Errorf("%s: unknown scene format %q, expected .txt or .json", output, outputFormat)
+    }
+    return os.WriteFile(output, contents, 0644)
+}
@@ -0,0 +1,91 @@
+package tracer
+
+import (
+    "bytes"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestJSONScenes(t *testing.T) {
+    text := []string{
+        "cam 0 0 5 -1 -1 4 1 -1 4 -1 1 4 1 1 4",
+        "res 64 48",
+        "samples 4 halton tent",
+        "depth 2",
+        "lta 0.1 0.1 0.1",
+        "ltp 0 5 5 1 1 1 2",
+        "sph 0 0 -3 1",
+        "mat 0.1 0.1 0.1 0.5 0.5 0.5 0.2 0.2 0.2 10 0 0 0",
+        "mtr 0.9 0.9 0.9 1.5",
+        "xft 1 0 0",
+        "xfr 0 45 0",
+        "sph 0 0 0 0.5",
+        "xfs 2 2 2",
+        "tri 0 0 0 1 0 0 0 1 0",
+        "xfz",
+        "mat 0 0 0 1 0 0 0 0 0 1 0.5 0.5 0.5",
+        "xft 0 -1 0",
+        "sph 0 -100 0 99",
+        "ltd 0 -1 -1 0.5 0.5 0.5",
+    }
+    directory := writeFixtures(t, map[string]string{"scene.txt": strings.Join(text, "\n")})
+    textFile := filepath.Join(directory, "scene.txt")
+    jsonFile := filepath.Join(directory, "scene.json")
+    if err := ConvertScene(textFile, jsonFile); err != nil {
+        t.Fatal(err)
+    }
+    fromText, err := ParseScene(textFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    fromJSON, err := ParseScene(jsonFile)
+    if err != nil {
+        t.Fatal(err)
+    }
+    fromText.bvh, fromJSON.bvh = nil, nil
+    if !reflect.DeepEqual(fromText, fromJSON) {
+        t.Errorf("Expected the same scene from text and JSON, got\n%+v\nand\n%+v", fromText, fromJSON)
+    }
+
+    // Back to text and JSON again gives the same JSON
+    backFile, againFile := filepath.Join(directory, "back.txt"), filepath.Join(directory, "again.json")
+    if err := ConvertScene(jsonFile, backFile); err != nil {
+        t.Fatal(err)
+    }
+    if err := ConvertScene(backFile, againFile); err != nil {
+        t.Fatal(err)
+    }
+    first, _ := os.ReadFile(jsonFile)
+    again, _ := os.ReadFile(againFile)
+    if !bytes.Equal(first, again) {
+        t.Errorf("Expected converting back and forth to keep the JSON, got\n%s\nand\n%s", first, again)
+    }
+
+    // The output's extension picks the format, and it must differ from the input's
+    for _, pair := range [][2]string{{textFile, "copy.txt"}, {jsonFile, "copy.json"}, {textFile, "scene.yaml"}, {jsonFile, "scene"}} {
+        output := filepath.Join(directory, pair[1])
+        if err := ConvertScene(pair[0], output); err == nil {
+            t.Errorf("Expected an error converting %s to %s", filepath.Base(pair[0]), pair[1])
+        }
+        if _, err := os.Stat(output); err == nil {
+            t.Errorf("Expected %s not to be written", pair[1])
+        }
+    }
+
+    for contents, path := range map[string]string{
+        `{"objects": [{"type": "sphere", "center": [0, 0, 0], "radius": 1, "material": "glass"}]}`: "objects[0].material",
+        `{"objects": [{"type": "sphere", "center": [0, 0, 0], "radius": 1, "transform": [{"scale": [0, 1, 1]}]}]}`: "objects[0].transform[0]",
+        `{"lights": [{"type": "spot", "color": [1, 1, 1]}]}`: "lights[0]",
+    } {
+        if err := os.WriteFile(jsonFile, []byte(contents), 0644); err != nil {
+            t.Fatal(err)
+        }
+        _, err := ParseScene(jsonFile)
+        if sceneErr, ok := err.(*SceneError); !ok || sceneErr.path != path {
+            t.Errorf("Expected an error at %s, got %v", path, err)
+        }
+    }
+}
@@ -0,0 +1,189 @@
+package tracer
+
+import (
+    "bytes"
+    "encoding/binary"
+    "fmt"
+    "math"
+    "os"
+    "strings"
+    "../vector"
+)
+
+// Builds a mesh from STL facets, welding corners at the same position
+type stlBuilder struct {
+    mesh *Mesh
+    positions map[raytracer.Vector]int32
+    normals map[raytracer.Vector]int32
+}
+
+func newStlBuilder(filename string) *stlBuilder {
+    return &stlBuilder{mesh: &Mesh{name: filename}, positions: map[raytracer.Vector]int32{}, normals: map[raytracer.Vector]int32{}}
+}
+
+// Adds a facet in file units. Facets without area are counted and left out.
+// A zero normal is common in STL files, those facets take the normal of
+// their winding.
+func (builder *stlBuilder) addFacet(normal raytracer.Vector, corners [3]raytracer.Vector) {
+    mesh := builder.mesh
+    cross := corners[1].VectorSub(corners[0]).CrossProduct(corners[2].VectorSub(corners[0]))
+    area := math.Sqrt(cross.DotProduct(cross))
+    if area == 0 || math.IsNaN(area) || math.IsInf(area, 0) {
+        mesh.skippedFaces++
+        return
+    }
+    if length := math.Sqrt(normal.DotProduct(normal)); length > 0 && !math.IsNaN(length) {
+        normal = normal.VectorDiv(length)
+    } else {
+        normal = cross.VectorDiv(area)
+    }
+    normalIndex, ok := builder.normals[normal]
+    if !ok {
+        normalIndex = int32(len(mesh.normals))
+        builder.normals[normal] = normalIndex
+        mesh.normals = append(mesh.normals, normal)
+    }
+
+    triangle := [3]MeshVertex{}
+    for i, corner := range corners {
+        position := corner.VectorScale(SCALE_FACTOR)
+        index, ok := builder.positions[position]
+        if !ok {
+            index = int32(len(mesh.positions))
+            builder.positions[position] = index
+            mesh.positions = append(mesh.positions, position)
+        }
+        triangle[i] = MeshVertex{position: index, normal: normalIndex, texcoord: -1}
+    }
+    mesh.triangles = append(mesh.triangles, triangle)
+}
+
+// Reads an ASCII or binary STL file. Triangles are shaded flat with the
+// facets' normals.
+func parseStl(filename string) (*Mesh, error) {
+    contents, err := os.ReadFile(filename)
+    if err != nil {
+        return nil, err
+    }
+    // Binary headers may start with "solid" too, so only a file that reads
+    // as text is ASCII. Some exporters pad binary files after the facets.
+    mesh, asciiErr := interpretStl(filename, strings.Split(string(contents), "\n"))
+    if asciiErr == nil {
+        return mesh, nil
+    }
+    if len(contents) >= 84 {
+        count := int64(binary.LittleEndian.Uint32(contents[80:84]))
+        if int64(len(contents)) >= 84 + 50*count {
+            return parseBinaryStl(filename, contents[84:], int(count)), nil
+        }
+    }
+    // Text never holds zero bytes, so the ASCII error is the useful one
+    if len(contents) > 0 && bytes.IndexByte(contents, 0) == -1 {
+        return nil, asciiErr
+    }
+    return nil, fmt.Errorf("%s: neither ASCII STL nor binary STL of the right size", filename)
+}
+
+// Each facet is 12 little endian float32s, a normal and three corners,
+// followed by two attribute bytes
+func parseBinaryStl(filename string, facets []byte, count int) *Mesh {
+    builder := newStlBuilder(filename)
+    for i := 0; i < count; i++ {
+        facet := facets[50*i:]
+        vectors := [4]raytracer.Vector{}
+        for j := range vectors {
+            component := func(k int) float64 {
+                return float64(math.Float32frombits(binary.LittleEndian.Uint32(facet[12*j + 4*k:])))
+            }
+            vectors[j] = raytracer.Vector{X: component(0), Y: component(1), Z: component(2)}
+        }
+        builder.addFacet(vectors[0], [3]raytracer.Vector{vectors[1], vectors[2], vectors[3]})
+    }
+    return builder.mesh
+}
+
+// Reads facet normal, vertex and endfacet lines between solid and endsolid,
+// the keywords between them only give structure. Keywords are matched in
+// any case.
+func interpretStl(filename string, lines []string) (*Mesh, error) {
+    builder := newStlBuilder(filename)
+    normal := emptyVector()
+    corners := []raytracer.Vector{}
+    inFacet, inSolid, solids := false, false, 0
+    for lineIndex, line := range lines {
+        tokens := tokenize(line)
+        if len(tokens) == 0 {
+            continue
+        }
+        stlError := func(column int, format string, a ...interface{}) error {
+            return &SceneError{filename: filename, line: lineIndex + 1, column: column, message: fmt.Sprintf(format, a...)}
+        }
+        readVector := func(arguments []token) (raytracer.Vector, error) {
+            if len(arguments) != 3 {
+                return emptyVector(), stlError(tokens[0].column, "expected 3 numbers, got %d", len(arguments))
+            }
+            numbers, badToken := parseNumbers(arguments)
+            if badToken != nil {
+                return emptyVector(), stlError(badToken.column, "expected number, got %q", badToken.text)
+            }
+            return vectorAt(numbers, 0), nil
+        }
+
+        keyword := tokens[0]
+        name := strings.ToLower(keyword.text)
+        if !inSolid && name != "solid" {
+            return nil, stlError(keyword.column, "expected solid, got %q", keyword.text)
+        }
+        switch name {
+        case "solid":
+            if inSolid {
+                return nil, stlError(keyword.column, "solid inside another solid")
+            }
+            inSolid = true
+            solids++
+        case "facet":
+            if inFacet {
+                return nil, stlError(keyword.column, "facet inside another facet")
+            }
+            if len(tokens) < 2 || !strings.EqualFold(tokens[1].text, "normal") {
+                return nil, stlError(keyword.column, "expected facet normal")
+            }
+            var err error
+            if normal, err = readVector(tokens[2:]); err != nil {
+                return nil, err
+            }
+            corners = corners[:0]
+            inFacet = true
+        case "vertex":
+            if !inFacet {
+                return nil, stlError(keyword.column, "vertex outside a facet")
+            }
+            corner, err := readVector(tokens[1:])
+            if err != nil {
+                return nil, err
+            }
+            corners = append(corners, corner)
+        case "endfacet":
+            if !inFacet || len(corners) != 3 {
+                return nil, stlError(keyword.column, "facet has %d vertices, expected 3", len(corners))
+            }
+            builder.addFacet(normal, [3]raytracer.Vector{corners[0], corners[1], corners[2]})
+            inFacet = false
+        case "endsolid":
+            if inFacet {
+                return nil, stlError(keyword.column, "endsolid inside a facet")
+            }
+            inSolid = false
+        case "outer", "endloop":
+        default:
+            return nil, stlError(keyword.column, "unknown keyword %q", keyword.text)
+        }
+    }
+    if solids == 0 {
+        return nil, fmt.Errorf("%s: no solid in the file", filename)
+    }
+    if inSolid {
+        return nil, fmt.Errorf("%s: file ends inside a solid", filename)
+    }
+    return builder.mesh, nil
+}
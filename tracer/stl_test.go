@@ -0,0 +1,86 @@
+package tracer
+
+import (
+    "bytes"
+    "encoding/binary"
+    "fmt"
+    "math"
+    "path/filepath"
+    "strings"
+    "testing"
+    "../vector"
+)
+
+func TestStlMeshes(t *testing.T) {
+    // A quad in two facets, one with a zero normal, and a degenerate facet
+    facets := [][4][3]float32{
+        {{0, 0, 1}, {0, 0, 0}, {1, 0, 0}, {1, 1, 0}},
+        {{0, 0, 0}, {0, 0, 0}, {1, 1, 0}, {0, 1, 0}},
+        {{0, 0, 1}, {0, 0, 0}, {1, 0, 0}, {2, 0, 0}},
+    }
+    var ascii strings.Builder
+    ascii.WriteString("solid quad\n")
+    for _, facet := range facets {
+        fmt.Fprintf(&ascii, "  facet normal %g %g %g\n    outer loop\n", facet[0][0], facet[0][1], facet[0][2])
+        for _, corner := range facet[1:] {
+            fmt.Fprintf(&ascii, "      vertex %g %g %g\n", corner[0], corner[1], corner[2])
+        }
+        ascii.WriteString("    endloop\n  endfacet\n")
+    }
+    ascii.WriteString("endsolid quad\n")
+    // Binary headers may start with "solid" too
+    var body bytes.Buffer
+    body.WriteString("solid binary")
+    body.Write(make([]byte, 80 - body.Len()))
+    binary.Write(&body, binary.LittleEndian, uint32(len(facets)))
+    for _, facet := range facets {
+        binary.Write(&body, binary.LittleEndian, facet)
+        binary.Write(&body, binary.LittleEndian, uint16(0))
+    }
+
+    quads := map[string]string{
+        "ascii.stl": ascii.String(),
+        "binary.stl": body.String(),
+        // Some exporters write keywords in upper case or pad binary files
+        "upper.stl": strings.ToUpper(ascii.String()),
+        "padded.stl": body.String() + string(make([]byte, 16)),
+    }
+    invalid := map[string]string{
+        "short.stl": "solid bad\nfacet normal 0 0 1\nouter loop\nvertex 0 0 0\nvertex 1 0 0\nendloop\nendfacet\nendsolid bad\n",
+        "number.stl": "solid bad\nfacet normal 0 0 x\n",
+        "truncated.stl": body.String()[:100],
+        "empty.stl": "",
+    }
+    files := map[string]string{}
+    for name, contents := range quads {
+        files[name] = contents
+    }
+    for name, contents := range invalid {
+        files[name] = contents
+    }
+    directory := writeFixtures(t, files)
+
+    for name := range quads {
+        mesh, err := parseStl(filepath.Join(directory, name))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(mesh.positions) != 4 || len(mesh.triangles) != 2 || mesh.skippedFaces != 1 {
+            t.Errorf("%s: expected a welded quad and one skipped facet, got %+v", name, mesh)
+        }
+        if len(mesh.normals) != 1 || mesh.normals[0] != (raytracer.Vector{X:0, Y:0, Z:1}) {
+            t.Errorf("%s: expected both facets to share the facet normal, got %+v", name, mesh.normals)
+        }
+        mesh.transform, mesh.material = identityTransform(), &Material{}
+        ray := Ray{start: raytracer.Vector{X:2, Y:8, Z:10}, direction: raytracer.Vector{X:0, Y:0, Z:-1}}
+        if hit, ok := (Triangle{mesh: mesh, index: 1}).Intersect(ray, 0, math.MaxFloat64); !ok || hit.shadingNormal.Z != 1 {
+            t.Errorf("%s: expected a hit with the facet normal, got %+v", name, hit)
+        }
+    }
+
+    for name := range invalid {
+        if _, err := parseStl(filepath.Join(directory, name)); err == nil {
+            t.Errorf("Expected an error for %s", name)
+        }
+    }
+}
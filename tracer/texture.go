@@ -0,0 +1,63 @@
+package tracer
+
+import (
+    "image"
+    "io"
+    "math"
+    "os"
+    "../vector"
+)
+
+// Texture is an image looked up by texture coordinates, with u running
+// right and v up from the bottom left corner, repeating outside [0, 1]
+type Texture struct {
+    width int
+    height int
+    pixels []raytracer.Vector
+}
+
+// Reads a PNG or JPEG image. Colors are used as they are stored, the way
+// the renderer writes them.
+func loadTexture(filename string) (*Texture, error) {
+    file, err := os.Open(filename)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    return decodeTexture(file)
+}
+
+func decodeTexture(input io.Reader) (*Texture, error) {
+    picture, _, err := image.Decode(input)
+    if err != nil {
+        return nil, err
+    }
+
+    bounds := picture.Bounds()
+    texture := &Texture{width: bounds.Dx(), height: bounds.Dy(), pixels: make([]raytracer.Vector, bounds.Dx()*bounds.Dy())}
+    for y := 0; y < texture.height; y++ {
+        for x := 0; x < texture.width; x++ {
+            r, g, b, _ := picture.At(bounds.Min.X + x, bounds.Min.Y + y).RGBA()
+            texture.pixels[y*texture.width + x] = raytracer.Vector{X: float64(r)/65535, Y: float64(g)/65535, Z: float64(b)/65535}
+        }
+    }
+    return texture, nil
+}
+
+func (texture *Texture) texel(x int, y int) raytracer.Vector {
+    x = (x%texture.width + texture.width)%texture.width
+    y = (y%texture.height + texture.height)%texture.height
+    return texture.pixels[y*texture.width + x]
+}
+
+// Bilinear lookup between the four nearest texel centers
+func (texture *Texture) sample(u float64, v float64) raytracer.Vector {
+    x := (u - math.Floor(u))*float64(texture.width) - 0.5
+    y := (1 - (v - math.Floor(v)))*float64(texture.height) - 0.5
+    x0, y0 := math.Floor(x), math.Floor(y)
+    fx, fy := x - x0, y - y0
+    left, top := int(x0), int(y0)
+    upper := texture.texel(left, top).VectorScale(1 - fx).VectorAdd(texture.texel(left + 1, top).VectorScale(fx))
+    lower := texture.texel(left, top + 1).VectorScale(1 - fx).VectorAdd(texture.texel(left + 1, top + 1).VectorScale(fx))
+    return upper.VectorScale(1 - fy).VectorAdd(lower.VectorScale(fy))
+}
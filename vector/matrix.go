@@ -0,0 +1,194 @@
+package raytracer
+
+import (
+    "math"
+)
+
+// Row major 4x4 matrix acting on column vectors
+type Matrix4 [4][4]float64
+
+func Identity() Matrix4 {
+    return Matrix4{
+        {1, 0, 0, 0},
+        {0, 1, 0, 0},
+        {0, 0, 1, 0},
+        {0, 0, 0, 1},
+    }
+}
+
+func Translation(t Vector) Matrix4 {
+    return Matrix4{
+        {1, 0, 0, t.X},
+        {0, 1, 0, t.Y},
+        {0, 0, 1, t.Z},
+        {0, 0, 0, 1},
+    }
+}
+
+func Scaling(s Vector) Matrix4 {
+    return Matrix4{
+        {s.X, 0, 0, 0},
+        {0, s.Y, 0, 0},
+        {0, 0, s.Z, 0},
+        {0, 0, 0, 1},
+    }
+}
+
+// Rotation about the axis r by |r| degrees (an exponential map), using
+// Rodrigues' formula R = I + sin(a)K + (1-cos(a))K^2
+func Rotation(r Vector) Matrix4 {
+    degrees := math.Sqrt(r.DotProduct(r))
+    if degrees == 0 {
+        return Identity()
+    }
+    angle := degrees*math.Pi/180
+    k := r.Normalize()
+    sin, cos := math.Sin(angle), 1 - math.Cos(angle)
+    return Matrix4{
+        {1 - cos*(k.Y*k.Y + k.Z*k.Z), -sin*k.Z + cos*k.X*k.Y, sin*k.Y + cos*k.X*k.Z, 0},
+        {sin*k.Z + cos*k.X*k.Y, 1 - cos*(k.X*k.X + k.Z*k.Z), -sin*k.X + cos*k.Y*k.Z, 0},
+        {-sin*k.Y + cos*k.X*k.Z, sin*k.X + cos*k.Y*k.Z, 1 - cos*(k.X*k.X + k.Y*k.Y), 0},
+        {0, 0, 0, 1},
+    }
+}
+
+// Camera to world matrix for a camera at eye looking at target, with the
+// camera's -Z axis pointing at the target and +Y as close to up as possible
+func LookAt(eye Vector, target Vector, up Vector) Matrix4 {
+    backward := eye.VectorSub(target).Normalize()
+    right := up.CrossProduct(backward).Normalize()
+    trueUp := backward.CrossProduct(right)
+    return Matrix4{
+        {right.X, trueUp.X, backward.X, eye.X},
+        {right.Y, trueUp.Y, backward.Y, eye.Y},
+        {right.Z, trueUp.Z, backward.Z, eye.Z},
+        {0, 0, 0, 1},
+    }
+}
+
+func (a Matrix4) Multiply(b Matrix4) Matrix4 {
+    var product Matrix4
+    for row := 0; row < 4; row++ {
+        for column := 0; column < 4; column++ {
+            product[row][column] = a[row][0]*b[0][column] + a[row][1]*b[1][column] + a[row][2]*b[2][column] + a[row][3]*b[3][column]
+        }
+    }
+    return product
+}
+
+func (a Matrix4) Transpose() Matrix4 {
+    var transposed Matrix4
+    for row := 0; row < 4; row++ {
+        for column := 0; column < 4; column++ {
+            transposed[column][row] = a[row][column]
+        }
+    }
+    return transposed
+}
+
+// Determinant of the 3x3 matrix left after removing a row and a column
+func (a Matrix4) minor(skipRow int, skipColumn int) float64 {
+    var m [3][3]float64
+    for row, i := 0, 0; row < 4; row++ {
+        if row == skipRow {
+            continue
+        }
+        for column, j := 0, 0; column < 4; column++ {
+            if column == skipColumn {
+                continue
+            }
+            m[i][j] = a[row][column]
+            j++
+        }
+        i++
+    }
+    return m[0][0]*(m[1][1]*m[2][2] - m[1][2]*m[2][1]) -
+           m[0][1]*(m[1][0]*m[2][2] - m[1][2]*m[2][0]) +
+           m[0][2]*(m[1][0]*m[2][1] - m[1][1]*m[2][0])
+}
+
+// Laplace expansion along the first row
+func (a Matrix4) Determinant() float64 {
+    return a[0][0]*a.minor(0, 0) - a[0][1]*a.minor(0, 1) + a[0][2]*a.minor(0, 2) - a[0][3]*a.minor(0, 3)
+}
+
+// Gauss-Jordan elimination with partial pivoting. The second value is false
+// when the matrix is singular.
+func (a Matrix4) Inverse() (Matrix4, bool) {
+    inverse := Identity()
+    for column := 0; column < 4; column++ {
+        pivot := column
+        for row := column + 1; row < 4; row++ {
+            if math.Abs(a[row][column]) > math.Abs(a[pivot][column]) {
+                pivot = row
+            }
+        }
+        if a[pivot][column] == 0 {
+            return Identity(), false
+        }
+        a[column], a[pivot] = a[pivot], a[column]
+        inverse[column], inverse[pivot] = inverse[pivot], inverse[column]
+
+        scale := 1/a[column][column]
+        for j := 0; j < 4; j++ {
+            a[column][j] *= scale
+            inverse[column][j] *= scale
+        }
+        for row := 0; row < 4; row++ {
+            if row == column || a[row][column] == 0 {
+                continue
+            }
+            factor := a[row][column]
+            for j := 0; j < 4; j++ {
+                a[row][j] -= factor*a[column][j]
+                inverse[row][j] -= factor*inverse[column][j]
+            }
+        }
+    }
+    return inverse, true
+}
+
+func (a Matrix4) TransformPoint(v Vector) Vector {
+    x := a[0][0]*v.X + a[0][1]*v.Y + a[0][2]*v.Z + a[0][3]
+    y := a[1][0]*v.X + a[1][1]*v.Y + a[1][2]*v.Z + a[1][3]
+    z := a[2][0]*v.X + a[2][1]*v.Y + a[2][2]*v.Z + a[2][3]
+    w := a[3][0]*v.X + a[3][1]*v.Y + a[3][2]*v.Z + a[3][3]
+    if w != 1 && w != 0 {
+        return Vector{X:x/w, Y:y/w, Z:z/w}
+    }
+    return Vector{X:x, Y:y, Z:z}
+}
+
+// Ignores the translation, for directions and offsets
+func (a Matrix4) TransformDirection(v Vector) Vector {
+    return Vector{
+        X: a[0][0]*v.X + a[0][1]*v.Y + a[0][2]*v.Z,
+        Y: a[1][0]*v.X + a[1][1]*v.Y + a[1][2]*v.Z,
+        Z: a[2][0]*v.X + a[2][1]*v.Y + a[2][2]*v.Z,
+    }
+}
+
+// Normals transform by the inverse transpose. The cofactor matrix is the
+// inverse transpose scaled by the determinant, so it is used instead and the
+// result normalized, flipping it back for mirroring matrices.
+func (a Matrix4) TransformNormal(n Vector) Vector {
+    c00 := a[1][1]*a[2][2] - a[1][2]*a[2][1]
+    c01 := a[1][2]*a[2][0] - a[1][0]*a[2][2]
+    c02 := a[1][0]*a[2][1] - a[1][1]*a[2][0]
+    c10 := a[0][2]*a[2][1] - a[0][1]*a[2][2]
+    c11 := a[0][0]*a[2][2] - a[0][2]*a[2][0]
+    c12 := a[0][1]*a[2][0] - a[0][0]*a[2][1]
+    c20 := a[0][1]*a[1][2] - a[0][2]*a[1][1]
+    c21 := a[0][2]*a[1][0] - a[0][0]*a[1][2]
+    c22 := a[0][0]*a[1][1] - a[0][1]*a[1][0]
+
+    transformed := Vector{
+        X: c00*n.X + c01*n.Y + c02*n.Z,
+        Y: c10*n.X + c11*n.Y + c12*n.Z,
+        Z: c20*n.X + c21*n.Y + c22*n.Z,
+    }.Normalize()
+    if a[0][0]*c00 + a[0][1]*c01 + a[0][2]*c02 < 0 {
+        return transformed.VectorScale(-1)
+    }
+    return transformed
+}